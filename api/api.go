@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"crypto/tls"
 	"log"
 	"os"
 	"sync"
@@ -30,6 +31,9 @@ type RoomAPIServer struct {
 	RoomDeadLine   time.Duration
 	Logger         *log.Logger
 
+	// GRPCTLSConfig enables TLS on connections to room servers if set.
+	GRPCTLSConfig *tls.Config
+
 	serverManager *ServerManager
 	roomManager   *RoomManager
 }
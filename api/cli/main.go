@@ -4,7 +4,7 @@ import (
 	"log"
 	"os"
 
-	api "github.com/iguagile/iguagile-api"
+	"github.com/iguagile/iguagile/api"
 )
 
 func main() {
@@ -14,5 +14,15 @@ func main() {
 	apiServer.RedisHost = os.Getenv("REDIS_HOST")
 	apiServer.MaxUser = 70
 	apiServer.Logger = log.New(os.Stdout, "iguagile-api ", log.Lshortfile)
+
+	if certFile := os.Getenv("GRPC_CERT_FILE"); certFile != "" {
+		config, err := api.NewClientTLSConfig(certFile, os.Getenv("GRPC_KEY_FILE"), os.Getenv("GRPC_CA_FILE"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		apiServer.GRPCTLSConfig = config
+	}
+
 	log.Fatal(apiServer.Start())
 }
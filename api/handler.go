@@ -9,6 +9,7 @@ import (
 	pb "github.com/iguagile/iguagile-room-proto/room"
 	"github.com/labstack/echo/v4"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 var (
@@ -36,7 +37,12 @@ func (s *RoomAPIServer) roomCreateHandler(c echo.Context) error {
 	}
 
 	grpcHost := fmt.Sprintf("%v:%v", server.Host, server.APIPort)
-	grpcConn, err := grpc.Dial(grpcHost, grpc.WithInsecure())
+	credential := grpc.WithInsecure()
+	if s.GRPCTLSConfig != nil {
+		credential = grpc.WithTransportCredentials(credentials.NewTLS(s.GRPCTLSConfig))
+	}
+
+	grpcConn, err := grpc.Dial(grpcHost, credential)
 	if err != nil {
 		return err
 	}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"log"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/iguagile/iguagile-engine/iguagile"
+	"github.com/iguagile/iguagile/engine/iguagile"
 )
 
 func main() {
@@ -31,10 +37,107 @@ func main() {
 		log.Fatal(err)
 	}
 
+	var roomTLSConfig *tls.Config
+	if certFile := os.Getenv("TLS_CERT_FILE"); certFile != "" {
+		roomTLSConfig, err = iguagile.NewServerTLSConfig(certFile, os.Getenv("TLS_KEY_FILE"), "")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		listener = tls.NewListener(listener, roomTLSConfig)
+		server.RequireTLS = true
+	}
+
+	if certFile := os.Getenv("GRPC_CERT_FILE"); certFile != "" {
+		config, err := iguagile.NewServerTLSConfig(certFile, os.Getenv("GRPC_KEY_FILE"), os.Getenv("GRPC_CA_FILE"))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		server.APITLSConfig = config
+	}
+
+	if os.Getenv("ENABLE_UDP") != "" {
+		packetConn, err := net.ListenPacket("udp", listener.Addr().String())
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			log.Fatal(server.ServeDatagram(packetConn))
+		}()
+	}
+
+	if webSocketAddress := os.Getenv("WEBSOCKET_HOST"); webSocketAddress != "" {
+		webSocketListener, err := net.Listen("tcp", webSocketAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if roomTLSConfig != nil {
+			webSocketListener = tls.NewListener(webSocketListener, roomTLSConfig)
+		}
+
+		go func() {
+			log.Fatal(server.ServeWebSocket(webSocketListener))
+		}()
+	}
+
 	port, err := strconv.Atoi(os.Getenv("GRPC_PORT"))
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	log.Fatal(server.Run(listener, port))
+	// The clients measure the round trip time and sync their clocks with the
+	// pings, that are answered by the relay rooms only if PING_INTERVAL is set.
+	server.PingInterval, err = durationEnv("PING_INTERVAL", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Relayed clients may be silent for long, so idle clients are closed only
+	// with pings keeping the others alive.
+	server.IdleTimeout, err = durationEnv("IDLE_TIMEOUT", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if server.IdleTimeout > 0 && server.PingInterval <= 0 {
+		log.Fatal("IDLE_TIMEOUT requires PING_INTERVAL")
+	}
+
+	shutdownTimeout, err := durationEnv("SHUTDOWN_TIMEOUT", time.Second*30)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		<-signals
+
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	if err := server.Run(listener, port); !errors.Is(err, iguagile.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	<-done
+}
+
+// durationEnv parses the environment variable as a duration, or returns the
+// default value if it is not set.
+func durationEnv(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+
+	return time.ParseDuration(value)
 }
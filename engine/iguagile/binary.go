@@ -1,6 +1,9 @@
 package iguagile
 
-import "errors"
+import (
+	"encoding/binary"
+	"errors"
+)
 
 // Traffic
 const (
@@ -8,17 +11,57 @@ const (
 	Outbound
 )
 
+// Targets
+// The values are on the wire, so new targets are added at the end.
+const (
+	AllClients = iota
+	OtherClients
+	Host
+	Server
+	AllClientsBuffered
+	OtherClientsBuffered
+	GroupClients
+	OtherGroupClients
+)
+
 // Message types
+// The values are on the wire, so new message types are added at the end.
 const (
 	NewConnect = iota
 	ExitConnect
+	Instantiate
+	Destroy
+	TransferOwnership
+	Transform
+	RPC
+	RequestOwnership
+	GrantOwnership
+	DenyOwnership
+	MigrateHost
+	BindDatagram
+	ServerShutdown
+	Interest
+	Position
+	JoinGroup
+	LeaveGroup
+	TransformSnapshot
+	TransformDelta
+	AckTransform
+	Ping
+	Pong
+	Leave
 )
 
+// serverIDByte is the id of messages sent by the server itself.
+// Client ids never reach it.
+var serverIDByte = []byte{0xff, 0xff}
+
 // BinaryData is client and server data transfer format.
 type BinaryData struct {
 	Traffic     int
 	ID          []byte
 	Target      byte
+	Group       int
 	MessageType byte
 	Payload     []byte
 }
@@ -27,11 +70,28 @@ type BinaryData struct {
 var ErrInvalidDataFormat = errors.New("invalid data length")
 
 // NewInBoundData return a BinaryData struct parsed and formatted binary.
+// The group targets are followed by the group id (2 bytes) before the
+// message type.
 func NewInBoundData(b []byte) (*BinaryData, error) {
 	if len(b) < 2 {
 		return nil, ErrInvalidDataFormat
 	}
 
+	if b[0] == GroupClients || b[0] == OtherGroupClients {
+		group, err := readGroupID(b[1:])
+		if err != nil || len(b) < groupIDSize+2 {
+			return nil, ErrInvalidDataFormat
+		}
+
+		return &BinaryData{
+			Traffic:     Inbound,
+			Target:      b[0],
+			Group:       group,
+			MessageType: b[groupIDSize+1],
+			Payload:     b[groupIDSize+2:],
+		}, nil
+	}
+
 	return &BinaryData{
 		Traffic:     Inbound,
 		Target:      b[0],
@@ -53,3 +113,33 @@ func NewOutBoundData(b []byte) (*BinaryData, error) {
 		Payload:     b[3:],
 	}, nil
 }
+
+// NewOutboundMessage returns an outbound message sent by the sender.
+func NewOutboundMessage(senderID []byte, messageType byte, payload []byte) []byte {
+	message := make([]byte, 0, len(senderID)+len(payload)+1)
+	message = append(message, senderID...)
+	message = append(message, messageType)
+	return append(message, payload...)
+}
+
+func encodeClientID(clientID int) []byte {
+	idByte := make([]byte, 2)
+	binary.LittleEndian.PutUint16(idByte, uint16(clientID))
+	return idByte
+}
+
+const objectIDSize = 4
+
+func readObjectID(payload []byte) (int, error) {
+	if len(payload) < objectIDSize {
+		return 0, ErrInvalidDataFormat
+	}
+
+	return int(binary.LittleEndian.Uint32(payload)), nil
+}
+
+func encodeObjectID(objectID int) []byte {
+	idByte := make([]byte, objectIDSize)
+	binary.LittleEndian.PutUint32(idByte, uint32(objectID))
+	return idByte
+}
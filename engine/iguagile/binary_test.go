@@ -29,6 +29,13 @@ func TestInbound(t *testing.T) {
 			MessageType: byte(4),
 			Payload:     []byte("HOGE")},
 		},
+		{append([]byte{GroupClients, 3, 1, 9}, []byte("TEAM")...), BinaryData{
+			Traffic:     Inbound,
+			Target:      GroupClients,
+			Group:       259,
+			MessageType: byte(9),
+			Payload:     []byte("TEAM")},
+		},
 	}
 	for _, v := range testData {
 		d, err := NewInBoundData(v.send)
@@ -44,6 +51,9 @@ func TestInbound(t *testing.T) {
 		if !reflect.DeepEqual(d.Target, v.want.Target) {
 			t.Errorf("missmatch Target get: %v , want: %v", d.Target, v.want.Target)
 		}
+		if d.Group != v.want.Group {
+			t.Errorf("missmatch Group get: %v , want: %v", d.Group, v.want.Group)
+		}
 		if !reflect.DeepEqual(d.Traffic, v.want.Traffic) {
 			t.Errorf("missmatch Traffic get: %v , want: %v", d.Traffic, v.want.Traffic)
 		}
@@ -99,3 +109,23 @@ func TestOutbound(t *testing.T) {
 		}
 	}
 }
+
+func TestTargetValues(t *testing.T) {
+	// Deployed clients depend on these values.
+	want := []int{AllClients, OtherClients, Host, Server}
+	for i, v := range want {
+		if v != i {
+			t.Errorf("invalid target value get: %v, want: %v", v, i)
+		}
+	}
+}
+
+func TestMessageTypeValues(t *testing.T) {
+	// Deployed clients depend on these values.
+	want := []int{NewConnect, ExitConnect, Instantiate, Destroy, TransferOwnership, Transform, RPC}
+	for i, v := range want {
+		if v != i {
+			t.Errorf("invalid message type value get: %v, want: %v", v, i)
+		}
+	}
+}
@@ -5,16 +5,59 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Client is a middleman between the connection and the room.
 type Client struct {
-	id     int
-	idByte []byte
-	conn   io.ReadWriteCloser
-	room   *Room
-	send   chan []byte
+	id          int
+	idByte      []byte
+	conn        io.ReadWriteCloser
+	room        *Room
+	connectedAt time.Time
+	latency     int64
+	tickEvents  int64
+
+	interest      InterestArea
+	interestMutex sync.Mutex
+
+	deltaTransforms atomic.Bool
+	fragmentation   atomic.Bool
+
+	received     int64
+	rtt          int64
+	jitter       int64
+	clockOffset  int64
+	pingMutex    sync.Mutex
+	pingSequence uint16
+	pingSentAt   time.Time
+
+	resumeToken   []byte
+	compressionID CompressionID
+	mutex         *sync.Mutex
+	done          chan struct{}
+	suspended     bool
+	closed        bool
+	missed        [][]byte
+	resumeTimer   *time.Timer
+
+	queue           []queuedMessage
+	notify          chan struct{}
+	space           chan struct{}
+	flush           chan struct{}
+	maxQueueDepth   int
+	droppedMessages int
+
+	sessionToken     []byte
+	datagram         *datagramTransport
+	datagramAddr     net.Addr
+	datagramMutex    sync.Mutex
+	sendSequence     uint16
+	receiveSequence  uint16
+	datagramReceived bool
 }
 
 // NewClient is Client constructed.
@@ -24,22 +67,34 @@ func NewClient(room *Room, conn io.ReadWriteCloser) (*Client, error) {
 		return nil, err
 	}
 
-	idByte := make([]byte, 2)
-	binary.LittleEndian.PutUint16(idByte, uint16(id))
+	var flush chan struct{}
+	if room.ticker != nil {
+		flush = make(chan struct{}, 1)
+	}
 
 	client := &Client{
-		id:     id,
-		idByte: idByte,
-		conn:   conn,
-		room:   room,
-		send:   make(chan []byte),
+		id:          id,
+		idByte:      encodeClientID(id),
+		conn:        conn,
+		room:        room,
+		connectedAt: time.Now(),
+		mutex:       &sync.Mutex{},
+		done:        make(chan struct{}),
+		notify:      make(chan struct{}, 1),
+		space:       make(chan struct{}),
+		flush:       flush,
 	}
 
 	return client, nil
 }
 
 func (c *Client) read(buf []byte) (int, error) {
-	_, err := c.conn.Read(buf[:2])
+	return readFrame(c.conn, buf)
+}
+
+// readFrame reads a frame into the buffer of maxFrameSize.
+func readFrame(conn io.Reader, buf []byte) (int, error) {
+	_, err := io.ReadFull(conn, buf[:2])
 	if err != nil {
 		return 0, err
 	}
@@ -47,7 +102,7 @@ func (c *Client) read(buf []byte) (int, error) {
 	size := int(binary.LittleEndian.Uint16(buf))
 	receivedSizeSum := 0
 	for receivedSizeSum < size {
-		receivedSize, err := c.conn.Read(buf[receivedSizeSum:size])
+		receivedSize, err := conn.Read(buf[receivedSizeSum:size])
 		if err != nil {
 			return 0, err
 		}
@@ -58,43 +113,242 @@ func (c *Client) read(buf []byte) (int, error) {
 	return size, nil
 }
 
-func (c *Client) readStart() {
-	buf := make([]byte, maxMessageSize)
+// ErrMessageTooLarge is when the reassembled message exceeds MaxMessageSize.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// Flags of the frames to the clients fragmenting messages
+const (
+	frameFinal byte = iota
+	frameMore
+)
+
+// Maximum size of a fragment following the flag in a frame.
+const maxFragmentSize = maxFrameSize - 1
+
+// readMessage reads a message. With the fragmentation, the message is
+// reassembled from the frames flagged with frameMore and the last frame
+// flagged with frameFinal. The buffer is used for messages in a frame. A
+// message reassembled from fragments larger than limit is rejected.
+func readMessage(conn io.Reader, buf []byte, limit int, fragmentation bool) ([]byte, error) {
+	n, err := readFrame(conn, buf)
+	if err != nil || !fragmentation {
+		return buf[:n], err
+	}
+
+	var message []byte
+	for {
+		if n == 0 || buf[0] > frameMore {
+			return nil, ErrInvalidDataFormat
+		}
+
+		if buf[0] == frameFinal && message == nil {
+			return buf[1:n], nil
+		}
+
+		if len(message)+n-1 > limit {
+			return nil, fmt.Errorf("%w %v", ErrMessageTooLarge, limit)
+		}
+		message = append(message, buf[1:n]...)
+		if buf[0] == frameFinal {
+			return message, nil
+		}
+
+		if n, err = readFrame(conn, buf); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readStart reads the connection until the session ends.
+func (c *Client) readStart(conn io.Reader, done chan struct{}, compression Compression) {
+	buf := make([]byte, maxFrameSize)
 	for {
-		n, err := c.read(buf)
+		limit := c.room.MaxMessageSize()
+		message, err := readMessage(conn, buf, limit, c.fragmentation.Load())
+		if err == nil {
+			message, err = decodeMessage(compression, message, max(limit, maxFrameSize))
+			if err != nil {
+				c.room.log.Println(err)
+				c.room.CloseConnection(c, DisconnectProtocolError)
+				break
+			}
+		}
+
+		if errors.Is(err, ErrMessageTooLarge) || errors.Is(err, ErrInvalidDataFormat) {
+			c.room.log.Println(err)
+			c.room.CloseConnection(c, DisconnectProtocolError)
+			break
+		}
+
 		if err != nil {
 			c.room.log.Println(err)
-			c.room.CloseConnection(c)
+			c.room.disconnect(c, done)
 			break
 		}
 
-		if err = c.room.service.Receive(c.id, buf[:n]); err != nil {
+		if err = c.room.receive(c, message); err != nil {
 			c.room.log.Println(err)
-			c.room.CloseConnection(c)
+			c.room.CloseConnection(c, DisconnectProtocolError)
 			break
 		}
 	}
 }
 
+// write writes the message in a frame without the flag, as the handshake
+// responses are.
 func (c *Client) write(message []byte) error {
-	size := len(message)
-	sizeByte := make([]byte, 2, size+2)
-	binary.LittleEndian.PutUint16(sizeByte, uint16(size))
-	message = append(sizeByte, message...)
-	if _, err := c.conn.Write(message); err != nil {
-		return err
+	return writeMessage(c.conn, message, false)
+}
+
+func writeMessage(conn io.Writer, message []byte, fragmentation bool) error {
+	_, err := conn.Write(appendFrame(make([]byte, 0, frameSize(message, fragmentation)), message, fragmentation))
+	return err
+}
+
+// appendFrame appends the message with the size to the buffer. With the
+// fragmentation, each frame starts with a flag, and a message larger than
+// maxFragmentSize is split into frames flagged with frameMore followed by the
+// last frame flagged with frameFinal. Otherwise the message must fit in a
+// frame.
+func appendFrame(buf, message []byte, fragmentation bool) []byte {
+	if !fragmentation {
+		buf = binary.LittleEndian.AppendUint16(buf, uint16(len(message)))
+		return append(buf, message...)
 	}
-	return nil
+
+	for len(message) > maxFragmentSize {
+		buf = binary.LittleEndian.AppendUint16(buf, maxFrameSize)
+		buf = append(buf, frameMore)
+		buf = append(buf, message[:maxFragmentSize]...)
+		message = message[maxFragmentSize:]
+	}
+
+	buf = binary.LittleEndian.AppendUint16(buf, uint16(len(message)+1))
+	buf = append(buf, frameFinal)
+	return append(buf, message...)
+}
+
+// frameSize returns the size of the message written in frames.
+func frameSize(message []byte, fragmentation bool) int {
+	if !fragmentation {
+		return len(message) + 2
+	}
+
+	frames := max(1, (len(message)+maxFragmentSize-1)/maxFragmentSize)
+	return len(message) + frames*3
+}
+
+// fitsFrame reports whether the message can be written to the client. The
+// client not fragmenting messages cannot receive a message larger than a
+// frame.
+func fitsFrame(message []byte, fragmentation bool) bool {
+	return fragmentation || len(message) <= maxFrameSize
 }
 
-func (c *Client) writeStart() {
+// Size of the buffer frames are coalesced into.
+const writeBufferSize = 64 * 1024
+
+var writeBufferPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, writeBufferSize)
+		return &buf
+	},
+}
+
+// writeFrames coalesces the messages into as few writes as the buffer allows.
+// It returns the index of the first message of the failed write.
+func writeFrames(conn io.Writer, messages [][]byte, fragmentation bool) (int, error) {
+	bufp := writeBufferPool.Get().(*[]byte)
+	buf := (*bufp)[:0]
+	defer func() {
+		// The buffer grown for a large message is left to the GC so that the
+		// pool does not keep it.
+		if cap(buf) <= writeBufferSize {
+			*bufp = buf[:0]
+			writeBufferPool.Put(bufp)
+		}
+	}()
+
+	first := 0
+	for i, message := range messages {
+		if len(buf) > 0 && len(buf)+frameSize(message, fragmentation) > cap(buf) {
+			if _, err := conn.Write(buf); err != nil {
+				return first, err
+			}
+			buf = buf[:0]
+			first = i
+		}
+		buf = appendFrame(buf, message, fragmentation)
+	}
+
+	if _, err := conn.Write(buf); err != nil {
+		return first, err
+	}
+	return len(messages), nil
+}
+
+// writeStart writes the enqueued messages to the connection until the session
+// ends. The queued messages are written together, once per FlushInterval if
+// it is set.
+func (c *Client) writeStart(conn io.Writer, done chan struct{}, compression Compression) {
+	if c.flush != nil {
+		c.flushLoop(conn, done, compression)
+		return
+	}
+
+	if interval := c.room.server.FlushInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		c.writeLoop(conn, done, compression, ticker.C)
+		return
+	}
+
+	c.writeLoop(conn, done, compression, nil)
+}
+
+func (c *Client) writeLoop(conn io.Writer, done chan struct{}, compression Compression, tick <-chan time.Time) {
 	for {
-		if err := c.write(<-c.send); err != nil {
-			c.room.log.Println(err)
-			c.room.CloseConnection(c)
-			break
+		messages, ok := c.dequeue(done)
+		if !ok {
+			return
 		}
+
+		if !c.writeMessages(conn, done, compression, messages) {
+			return
+		}
+
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+// writeMessages writes the messages together, and disconnects the session of
+// done if it fails. The messages too large for the client are dropped.
+func (c *Client) writeMessages(conn io.Writer, done chan struct{}, compression Compression, messages [][]byte) bool {
+	fragmentation := c.fragmentation.Load()
+	written := make([][]byte, 0, len(messages))
+	encoded := make([][]byte, 0, len(messages))
+	for _, message := range messages {
+		data := c.encodeMessage(compression, message)
+		if !fitsFrame(data, fragmentation) {
+			c.room.log.Printf("%v for client %v: %v bytes\n", ErrMessageTooLarge, c.id, len(data))
+			continue
+		}
+		written = append(written, message)
+		encoded = append(encoded, data)
+	}
+
+	if i, err := writeFrames(conn, encoded, fragmentation); err != nil {
+		c.room.log.Println(err)
+		c.room.disconnect(c, done, written[i:]...)
+		return false
 	}
+	return true
 }
 
 // GetID is getter for id.
@@ -107,14 +361,103 @@ func (c *Client) GetIDByte() []byte {
 	return c.idByte
 }
 
+// GetConnectedAt returns the time the client connected.
+func (c *Client) GetConnectedAt() time.Time {
+	return c.connectedAt
+}
+
+// Latency returns the latency of the client.
+func (c *Client) Latency() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.latency))
+}
+
+// SetLatency updates the latency of the client used for the host election.
+// The engine sets it to half of the RTT when the client answers a ping.
+func (c *Client) SetLatency(latency time.Duration) {
+	atomic.StoreInt64(&c.latency, int64(latency))
+}
+
 // Send is enqueue outbound messages.
+// Messages sent while the client is disconnected are kept until the client
+// resumes the session. The message is copied, since the senders may reuse the
+// buffer such as the one of the reader.
 func (c *Client) Send(message []byte) {
-	c.send <- message
+	c.send(append([]byte{}, message...), true)
+}
+
+// SendUnreliable sends the message over the datagram transport if the client
+// is bound to it, otherwise enqueues the message as the message may be dropped
+// on overflow.
+func (c *Client) SendUnreliable(message []byte) {
+	c.send(append([]byte{}, message...), false)
+}
+
+// send sends the message the caller does not modify anymore, so that the
+// message is shared by the recipients of a broadcast.
+func (c *Client) send(message []byte, reliable bool) {
+	if reliable || !c.sendDatagram(message) {
+		c.enqueue(message, reliable)
+	}
+}
+
+// sendDatagram sends the message over the datagram transport, and reports
+// whether the client is bound to it.
+func (c *Client) sendDatagram(message []byte) bool {
+	c.datagramMutex.Lock()
+	transport, addr := c.datagram, c.datagramAddr
+	sequence := c.sendSequence
+	c.sendSequence++
+	c.datagramMutex.Unlock()
+
+	if addr == nil {
+		return false
+	}
+
+	if err := transport.send(addr, sequence, message); err != nil {
+		c.room.log.Println(err)
+	}
+	return true
+}
+
+// acceptSequence reports whether the datagram with the sequence number is
+// newer than the datagrams received so far.
+func (c *Client) acceptSequence(sequence uint16) bool {
+	c.datagramMutex.Lock()
+	defer c.datagramMutex.Unlock()
+	if c.datagramReceived && !sequenceLess(c.receiveSequence, sequence) {
+		return false
+	}
+
+	c.receiveSequence = sequence
+	c.datagramReceived = true
+	return true
+}
+
+// bindDatagram binds the address on the transport to the client and returns
+// the old address.
+func (c *Client) bindDatagram(transport *datagramTransport, addr net.Addr) net.Addr {
+	c.datagramMutex.Lock()
+	defer c.datagramMutex.Unlock()
+	old := c.datagramAddr
+	c.datagram = transport
+	c.datagramAddr = addr
+	return old
+}
+
+// datagramSession returns the datagram transport the client uses and the
+// session token issued to the client.
+func (c *Client) datagramSession() (*datagramTransport, []byte) {
+	c.datagramMutex.Lock()
+	defer c.datagramMutex.Unlock()
+	return c.datagram, c.sessionToken
 }
 
 // Close closes the connection.
 func (c *Client) Close() error {
-	return c.conn.Close()
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+	return conn.Close()
 }
 
 // ClientManager manages clients.
@@ -158,6 +501,27 @@ func (m *ClientManager) Add(client *Client) error {
 	return nil
 }
 
+// ErrRoomFull is when connected clients reach the room capacity.
+var ErrRoomFull = errors.New("connected clients exceed room capacity")
+
+// AddWithLimit adds the client if the number of clients is less than the limit.
+func (m *ClientManager) AddWithLimit(client *Client, limit int) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.count >= limit {
+		return fmt.Errorf("%w %v %v", ErrRoomFull, limit, m.count)
+	}
+
+	if _, ok := m.clients[client.GetID()]; ok {
+		return fmt.Errorf("client already exists %v", client.GetID())
+	}
+
+	m.clients[client.GetID()] = client
+	m.count++
+	return nil
+}
+
 // Remove the client.
 func (m *ClientManager) Remove(clientID int) {
 	m.Lock()
@@ -193,9 +557,41 @@ func (m *ClientManager) Clear() {
 
 // Count clients.
 func (m *ClientManager) Count() int {
+	m.Lock()
+	defer m.Unlock()
 	return m.count
 }
 
+// Clients returns a snapshot of the clients.
+func (m *ClientManager) Clients() []*Client {
+	m.Lock()
+	defer m.Unlock()
+	clients := make([]*Client, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// Elect elects a client with the HostElection.
+// Suspended clients are not candidates, since they can not act as the host
+// until they resume the session.
+func (m *ClientManager) Elect(election HostElection) (*Client, error) {
+	clients := m.Clients()
+	candidates := clients[:0]
+	for _, client := range clients {
+		if !client.isSuspended() {
+			candidates = append(candidates, client)
+		}
+	}
+
+	if client := election(candidates); client != nil {
+		return client, nil
+	}
+
+	return nil, ErrNoHostCandidate
+}
+
 // First returns a first element.
 func (m *ClientManager) First() (*Client, error) {
 	m.Lock()
@@ -0,0 +1,139 @@
+package iguagile
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+	fail   int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.writes == w.fail {
+		return 0, errors.New("write failed")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestWriteFrames(t *testing.T) {
+	small := [][]byte{{1}, {2, 3}, {4, 5, 6}}
+	w := &countingWriter{}
+	if _, err := writeFrames(w, small, false); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{1, 0, 1, 2, 0, 2, 3, 3, 0, 4, 5, 6}
+	if w.writes != 1 || !bytes.Equal(w.Bytes(), want) {
+		t.Errorf("invalid frames get: %v in %v writes, want: %v", w.Bytes(), w.writes, want)
+	}
+
+	large := make([][]byte, 3)
+	for i := range large {
+		large[i] = make([]byte, writeBufferSize/2)
+	}
+
+	w = &countingWriter{}
+	if _, err := writeFrames(w, large, false); err != nil {
+		t.Fatal(err)
+	}
+	if w.writes != 3 || w.Len() != len(large)*(writeBufferSize/2+2) {
+		t.Errorf("invalid frames %v bytes in %v writes", w.Len(), w.writes)
+	}
+
+	w = &countingWriter{fail: 2}
+	if i, err := writeFrames(w, large, false); err == nil || i != 1 {
+		t.Errorf("invalid failed message index %v %v", i, err)
+	}
+}
+
+func TestWriteBufferPool(t *testing.T) {
+	w := &countingWriter{}
+	if _, err := writeFrames(w, [][]byte{make([]byte, writeBufferSize*2)}, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool may drop the buffers, so only the buffers kept are checked.
+	for i := 0; i < 8; i++ {
+		bufp := writeBufferPool.Get().(*[]byte)
+		if cap(*bufp) > writeBufferSize {
+			t.Fatalf("grown buffer of %v bytes pooled", cap(*bufp))
+		}
+	}
+}
+
+func TestFragmentation(t *testing.T) {
+	for _, size := range []int{0, maxFragmentSize, maxFrameSize, maxFrameSize*2 + 10} {
+		message := make([]byte, size)
+		for i := range message {
+			message[i] = byte(i)
+		}
+
+		w := &bytes.Buffer{}
+		if err := writeMessage(w, message, true); err != nil {
+			t.Fatal(err)
+		}
+		if w.Len() != frameSize(message, true) {
+			t.Errorf("invalid frame size get: %v, want: %v", frameSize(message, true), w.Len())
+		}
+
+		received, err := readMessage(w, make([]byte, maxFrameSize), len(message), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(received, message) {
+			t.Errorf("invalid reassembled message of size %v", size)
+		}
+	}
+
+	w := &bytes.Buffer{}
+	if err := writeMessage(w, make([]byte, maxFrameSize*2), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMessage(w, make([]byte, maxFrameSize), maxFrameSize*2-1, true); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("too large message accepted %v", err)
+	}
+
+	w = &bytes.Buffer{}
+	w.Write([]byte{2, 0, 2, 0})
+	if _, err := readMessage(w, make([]byte, maxFrameSize), maxFrameSize, true); !errors.Is(err, ErrInvalidDataFormat) {
+		t.Errorf("invalid frame flag accepted %v", err)
+	}
+}
+
+func TestFrameWithoutFragmentation(t *testing.T) {
+	// A frame of the maximum size is a whole message to the clients not
+	// fragmenting messages.
+	message := make([]byte, maxFrameSize)
+	message[len(message)-1] = 1
+
+	w := &bytes.Buffer{}
+	if err := writeMessage(w, message, false); err != nil {
+		t.Fatal(err)
+	}
+	if w.Len() != frameSize(message, false) {
+		t.Errorf("invalid frame size get: %v, want: %v", frameSize(message, false), w.Len())
+	}
+
+	w.Write([]byte{1, 0, 9})
+	received, err := readMessage(w, make([]byte, maxFrameSize), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, message) {
+		t.Errorf("invalid message of size %v", len(received))
+	}
+
+	received, err = readMessage(w, make([]byte, maxFrameSize), 0, false)
+	if err != nil || !bytes.Equal(received, []byte{9}) {
+		t.Errorf("invalid next message get: %v %v, want: %v", received, err, []byte{9})
+	}
+
+	if fitsFrame(make([]byte, maxFrameSize+1), false) || !fitsFrame(make([]byte, maxFrameSize+1), true) {
+		t.Error("invalid frame limit")
+	}
+}
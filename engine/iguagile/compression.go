@@ -0,0 +1,161 @@
+package iguagile
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CompressionID identifies the compression negotiated in the handshake.
+type CompressionID byte
+
+// Compression ids
+const (
+	CompressionNone CompressionID = iota
+	CompressionDeflate
+)
+
+// Compression compresses the messages over the connection.
+//
+// RoomServer supports deflate by default. Other compressions are enabled by
+// adding the implementation to RoomServer.Compressions with an id the clients
+// agree on.
+type Compression interface {
+	// Compress returns the compressed message.
+	Compress(message []byte) ([]byte, error)
+
+	// Decompress returns the decompressed message, or an error if the message
+	// is larger than limit.
+	Decompress(message []byte, limit int) ([]byte, error)
+}
+
+// Flags prefixed to the messages when the compression is negotiated.
+const (
+	messageRaw byte = iota
+	messageCompressed
+)
+
+// ErrInvalidCompressionFlag is when the message has an unknown flag.
+var ErrInvalidCompressionFlag = errors.New("invalid compression flag")
+
+// DeflateCompression is the per-message deflate compression.
+type DeflateCompression struct {
+	level   int
+	writers *sync.Pool
+}
+
+// NewDeflateCompression is DeflateCompression constructed with the level of
+// compress/flate.
+func NewDeflateCompression(level int) (*DeflateCompression, error) {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, err
+	}
+
+	return &DeflateCompression{level: level, writers: &sync.Pool{}}, nil
+}
+
+// Compress for implement Compression.
+func (d *DeflateCompression) Compress(message []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w, ok := d.writers.Get().(*flate.Writer)
+	if ok {
+		w.Reset(buf)
+	} else {
+		var err error
+		if w, err = flate.NewWriter(buf, d.level); err != nil {
+			return nil, err
+		}
+	}
+	defer d.writers.Put(w)
+
+	if _, err := w.Write(message); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress for implement Compression.
+func (d *DeflateCompression) Decompress(message []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(message))
+	defer func() {
+		_ = r.Close()
+	}()
+
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > limit {
+		return nil, fmt.Errorf("%w %v", ErrMessageTooLarge, limit)
+	}
+
+	return data, nil
+}
+
+// negotiateCompression chooses the first compression the server supports in
+// the compressions the client offers.
+func (s *RoomServer) negotiateCompression(offers []CompressionID) CompressionID {
+	for _, id := range offers {
+		if _, ok := s.Compressions[id]; ok && id != CompressionNone {
+			return id
+		}
+	}
+
+	return CompressionNone
+}
+
+// compression returns the compression of the id, or nil for CompressionNone.
+func (s *RoomServer) compression(id CompressionID) Compression {
+	if id == CompressionNone {
+		return nil
+	}
+
+	return s.Compressions[id]
+}
+
+// encodeMessage prefixes the flag to the message, and compresses the message
+// if it is not smaller than CompressionThreshold.
+func (c *Client) encodeMessage(compression Compression, message []byte) []byte {
+	if compression == nil {
+		return message
+	}
+
+	if len(message) >= c.room.server.CompressionThreshold {
+		compressed, err := compression.Compress(message)
+		if err != nil {
+			c.room.log.Println(err)
+		} else if len(compressed) < len(message) {
+			return append([]byte{messageCompressed}, compressed...)
+		}
+	}
+
+	return append([]byte{messageRaw}, message...)
+}
+
+// decodeMessage removes the flag from the message and decompresses it.
+func decodeMessage(compression Compression, message []byte, limit int) ([]byte, error) {
+	if compression == nil {
+		return message, nil
+	}
+
+	if len(message) == 0 {
+		return nil, ErrInvalidDataFormat
+	}
+
+	switch message[0] {
+	case messageRaw:
+		return message[1:], nil
+	case messageCompressed:
+		return compression.Decompress(message[1:], limit)
+	default:
+		return nil, fmt.Errorf("%w %v", ErrInvalidCompressionFlag, message[0])
+	}
+}
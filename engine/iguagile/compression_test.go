@@ -0,0 +1,106 @@
+package iguagile
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestDeflateCompression(t *testing.T) {
+	deflate, err := NewDeflateCompression(flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := bytes.Repeat([]byte("transform"), 100)
+	compressed, err := deflate.Compress(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(message) {
+		t.Errorf("message is not compressed %v %v", len(compressed), len(message))
+	}
+
+	decompressed, err := deflate.Decompress(compressed, len(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decompressed, message) {
+		t.Errorf("invalid decompressed message %v", decompressed)
+	}
+
+	if _, err := deflate.Decompress(compressed, len(message)-1); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("too large message accepted %v", err)
+	}
+
+	if _, err := NewDeflateCompression(100); err == nil {
+		t.Error("invalid level accepted")
+	}
+}
+
+func TestNegotiateCompression(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deflate, err := NewDeflateCompression(flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.Compressions = map[CompressionID]Compression{CompressionDeflate: deflate}
+	room.server.CompressionThreshold = 16
+
+	serverConn, conn := net.Pipe()
+	go func() {
+		if err := room.server.Serve(serverConn); err != nil {
+			_ = serverConn.Close()
+		}
+	}()
+
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, roomID)
+	id = append(id, handshakeOptionCompression, 2, byte(CompressionDeflate+1), byte(CompressionDeflate))
+	for _, data := range [][]byte{id, []byte(appName), []byte(appVersion), []byte(password), roomToken} {
+		if err := send(conn, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != byte(HandshakeSucceeded) || CompressionID(buf[n-1]) != CompressionDeflate {
+		t.Fatalf("deflate is not negotiated %v", buf[:n])
+	}
+
+	message := append([]byte{AllClients, RPC}, bytes.Repeat([]byte("rpc"), 100)...)
+	compressed, err := deflate.Compress(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := send(conn, append([]byte{messageCompressed}, compressed...)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf[0] != messageCompressed {
+		t.Fatalf("message is not compressed %v", buf[:n])
+	}
+
+	received, err := deflate.Decompress(buf[1:n], maxMessageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, message) {
+		t.Errorf("invalid message get: %v, want: %v", received, message)
+	}
+}
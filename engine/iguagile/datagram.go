@@ -0,0 +1,238 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// Datagram kinds
+const (
+	datagramBind = iota
+	datagramData
+	datagramReliable
+	datagramAck
+	datagramClose
+)
+
+const (
+	sessionTokenSize = 16
+	sequenceSize     = 2
+
+	// Number of datagrams queued for a room before they are dropped.
+	datagramQueueSize = 256
+)
+
+// datagramTransport delivers messages over a packet connection.
+//
+// Datagrams of kind datagramData carry a sequence number followed by a
+// message. They are unreliable and sequenced, so that the receiver drops
+// datagrams older than the latest one received.
+//
+// A client registered with the stream connection receives a BindDatagram
+// message with a session token. The client binds its address to the session
+// by sending a datagram of kind datagramBind followed by the token, and the
+// server answers with a datagramBind datagram.
+//
+// A client can also connect with the datagrams only. The reliable and ordered
+// channel made of datagramReliable, datagramAck and datagramClose is used as
+// the stream connection, and the address is bound to the client when the
+// handshake succeeds.
+type datagramTransport struct {
+	conn     net.PacketConn
+	server   *RoomServer
+	sessions *sync.Map
+	clients  *sync.Map
+	conns    *sync.Map
+
+	pendingConns int64
+}
+
+func newDatagramTransport(server *RoomServer, conn net.PacketConn) *datagramTransport {
+	return &datagramTransport{
+		conn:     conn,
+		server:   server,
+		sessions: &sync.Map{},
+		clients:  &sync.Map{},
+		conns:    &sync.Map{},
+	}
+}
+
+// ServeDatagram receives datagrams from clients.
+func (s *RoomServer) ServeDatagram(conn net.PacketConn) error {
+	transport := newDatagramTransport(s, conn)
+	s.datagram.Store(transport)
+	defer s.datagram.Store(nil)
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		if err := transport.receive(addr, buf[:n]); err != nil {
+			s.logger.Println(err)
+		}
+	}
+}
+
+// sequenceLess reports whether the sequence number a is older than b.
+func sequenceLess(a, b uint16) bool {
+	return int16(a-b) < 0
+}
+
+func (t *datagramTransport) receive(addr net.Addr, data []byte) error {
+	if len(data) == 0 {
+		return ErrInvalidDataFormat
+	}
+
+	switch data[0] {
+	case datagramBind:
+		return t.bind(addr, data[1:])
+	case datagramData:
+		if len(data) < sequenceSize+1 {
+			return ErrInvalidDataFormat
+		}
+
+		c, ok := t.clients.Load(addr.String())
+		if !ok {
+			return fmt.Errorf("datagram from unbound address %v", addr)
+		}
+
+		client := c.(*Client)
+		if !client.acceptSequence(binary.LittleEndian.Uint16(data[1:])) {
+			return nil
+		}
+
+		client.room.receiveDatagram(client, data[sequenceSize+1:])
+		return nil
+	case datagramReliable, datagramAck, datagramClose:
+		if c, ok := t.conns.Load(addr.String()); ok {
+			return c.(*reliableConn).receive(data)
+		}
+
+		if data[0] != datagramReliable || len(data) < sequenceSize+1 || binary.LittleEndian.Uint16(data[1:]) != 0 {
+			return fmt.Errorf("datagram from unknown connection %v", addr)
+		}
+
+		if t.server.RequireTLS {
+			return fmt.Errorf("%w: datagram handshake from %v", ErrTLSRequired, addr)
+		}
+
+		// Connections are made by any address, so the ones in the handshake
+		// are limited as well as the handshakes.
+		if atomic.AddInt64(&t.pendingConns, 1) > int64(t.server.MaxPendingHandshakes) {
+			atomic.AddInt64(&t.pendingConns, -1)
+			return ErrTooManyHandshakes
+		}
+
+		conn := newReliableConn(t, addr)
+		t.conns.Store(addr.String(), conn)
+		go func() {
+			err := t.server.Serve(conn)
+			atomic.AddInt64(&t.pendingConns, -1)
+			if err != nil {
+				t.server.logger.Println(err)
+				_ = conn.Close()
+			}
+		}()
+		return conn.receive(data)
+	default:
+		return fmt.Errorf("invalid datagram kind %v", data[0])
+	}
+}
+
+// inboundDatagram is a message received over the datagram transport.
+type inboundDatagram struct {
+	client  *Client
+	message []byte
+}
+
+// receiveDatagram queues the message for the room, so that a slow room does
+// not stall the datagrams of the other rooms read on the same goroutine.
+// The message is dropped if the queue is full, as the datagrams are
+// unreliable.
+func (r *Room) receiveDatagram(client *Client, message []byte) {
+	r.datagramOnce.Do(func() {
+		go r.receiveDatagrams()
+	})
+
+	// The buffer of the message is reused by the reader.
+	select {
+	case r.datagrams <- inboundDatagram{client: client, message: append([]byte{}, message...)}:
+	default:
+	}
+}
+
+// receiveDatagrams passes the messages queued to the room until it is closed.
+func (r *Room) receiveDatagrams() {
+	for {
+		select {
+		case datagram := <-r.datagrams:
+			if datagram.client.isClosed() {
+				continue
+			}
+
+			if err := r.receive(datagram.client, datagram.message); err != nil {
+				r.log.Println(err)
+				r.CloseConnection(datagram.client, DisconnectProtocolError)
+			}
+		case <-r.closing:
+			return
+		}
+	}
+}
+
+func (t *datagramTransport) bind(addr net.Addr, token []byte) error {
+	c, ok := t.sessions.Load(string(token))
+	if !ok {
+		return fmt.Errorf("invalid session token %v", token)
+	}
+
+	t.bindClient(c.(*Client), addr)
+
+	_, err := t.conn.WriteTo([]byte{datagramBind}, addr)
+	return err
+}
+
+func (t *datagramTransport) bindClient(client *Client, addr net.Addr) {
+	if old := client.bindDatagram(t, addr); old != nil && old.String() != addr.String() {
+		t.clients.Delete(old.String())
+	}
+	t.clients.Store(addr.String(), client)
+}
+
+// open issues a session token to the client.
+func (t *datagramTransport) open(client *Client) []byte {
+	token := uuid.New()
+	client.datagramMutex.Lock()
+	client.sessionToken = token[:]
+	client.datagram = t
+	client.datagramMutex.Unlock()
+	t.sessions.Store(string(token[:]), client)
+	return token[:]
+}
+
+// close discards the session of the client.
+func (t *datagramTransport) close(client *Client) {
+	if _, token := client.datagramSession(); token != nil {
+		t.sessions.Delete(string(token))
+	}
+	if addr := client.bindDatagram(t, nil); addr != nil {
+		t.clients.Delete(addr.String())
+	}
+}
+
+func (t *datagramTransport) send(addr net.Addr, sequence uint16, message []byte) error {
+	datagram := make([]byte, sequenceSize+1, len(message)+sequenceSize+1)
+	datagram[0] = datagramData
+	binary.LittleEndian.PutUint16(datagram[1:], sequence)
+	datagram = append(datagram, message...)
+	_, err := t.conn.WriteTo(datagram, addr)
+	return err
+}
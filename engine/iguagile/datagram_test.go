@@ -0,0 +1,158 @@
+package iguagile
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDatagram(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = serverConn.Close() }()
+
+	go func() {
+		_ = room.server.ServeDatagram(serverConn)
+	}()
+	for room.server.datagram.Load() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var token []byte
+	select {
+	case message := <-client.messages:
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.MessageType != BindDatagram || len(data.Payload) != sessionTokenSize {
+			t.Fatalf("invalid bind message %v", message)
+		}
+		token = data.Payload
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting session token")
+	}
+
+	conn, err := net.Dial("udp", serverConn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	if err := conn.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Write(append([]byte{datagramBind}, token...)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte{datagramBind}) {
+		t.Fatalf("invalid bind response %v", buf[:n])
+	}
+
+	instantiate := objectPayload(1, ownerExist)
+	if err := send(client.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+	client.expect(t, client.id, Instantiate, instantiate)
+
+	transform := objectPayload(1, 1, 2, 3)
+	if _, err := conn.Write(append([]byte{datagramData, 0, 0, AllClients, Transform}, transform...)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := append([]byte{datagramData, 0, 0}, NewOutboundMessage(encodeClientID(client.id), Transform, transform)...)
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("invalid datagram get: %v, want: %v", buf[:n], want)
+	}
+}
+
+type slowTestService struct {
+	*RelayService
+	release chan struct{}
+}
+
+func (s *slowTestService) Receive(int, []byte) error {
+	<-s.release
+	return nil
+}
+
+type slowTestServiceFactory struct {
+	service *slowTestService
+}
+
+func (f slowTestServiceFactory) Create(room *Room) (RoomService, error) {
+	f.service.RelayService = &RelayService{room: room}
+	return f.service, nil
+}
+
+func TestSlowRoomDatagrams(t *testing.T) {
+	service := &slowTestService{release: make(chan struct{})}
+	defer close(service.release)
+	slowRoom, err := newTestRoom(slowTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slowClient, err := joinTestRoom(slowRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reader of the datagrams is not stalled by the room not processing
+	// them, and the datagrams over the queue are dropped.
+	slowSender, err := slowRoom.clientManager.Get(slowClient.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < datagramQueueSize+2; i++ {
+		slowRoom.receiveDatagram(slowSender, []byte{AllClients, RPC, 1})
+	}
+
+	sender, err := room.clientManager.Get(client.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte{AllClients, RPC, 2}
+	room.receiveDatagram(sender, data)
+
+	select {
+	case message := <-client.messages:
+		if !bytes.Equal(message, data) {
+			t.Errorf("invalid message get: %v, want: %v", message, data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting datagram")
+	}
+}
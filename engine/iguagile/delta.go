@@ -0,0 +1,144 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// Number of transforms kept per GameObject as the baseline of deltas and to
+// rewind the room.
+const transformHistorySize = 64
+
+// Unchanged bytes shorter than this between changes are sent in the change.
+const minDeltaGap = 3
+
+type transformState struct {
+	sequence uint16
+	at       time.Time
+	data     []byte
+	position *Vector3
+}
+
+// updateTransform records the transform updated at the time with a new
+// sequence number.
+func (o *GameObject) updateTransform(transform []byte, at time.Time) uint16 {
+	o.transformSequence++
+	o.transform = transform
+	o.transformHistory[o.transformSequence%transformHistorySize] = transformState{
+		sequence: o.transformSequence,
+		at:       at,
+		data:     transform,
+		position: o.position,
+	}
+	return o.transformSequence
+}
+
+// historyState returns the state of the sequence number if it is kept.
+func (o *GameObject) historyState(sequence uint16) (transformState, bool) {
+	state := o.transformHistory[sequence%transformHistorySize]
+	if state.data == nil || state.sequence != sequence {
+		return transformState{}, false
+	}
+	return state, true
+}
+
+// historyTransform returns the transform of the sequence number if it is kept.
+func (o *GameObject) historyTransform(sequence uint16) ([]byte, bool) {
+	state, ok := o.historyState(sequence)
+	return state.data, ok
+}
+
+// ackTransform records the transform the client received, and reports
+// whether the sequence number is known.
+func (o *GameObject) ackTransform(clientID int, sequence uint16) bool {
+	if _, ok := o.historyTransform(sequence); !ok {
+		return false
+	}
+
+	if acked, ok := o.acks[clientID]; ok && !sequenceLess(acked, sequence) {
+		return true
+	}
+
+	if o.acks == nil {
+		o.acks = make(map[int]uint16)
+	}
+	o.acks[clientID] = sequence
+	return true
+}
+
+// baseline returns the latest transform the client acknowledged.
+func (o *GameObject) baseline(clientID int) (uint16, []byte, bool) {
+	sequence, ok := o.acks[clientID]
+	if !ok {
+		return 0, nil, false
+	}
+
+	data, ok := o.historyTransform(sequence)
+	return sequence, data, ok
+}
+
+// encodeDelta returns the changes of the state from the baseline as a list of
+// offset (uvarint) | length (uvarint) | bytes. It returns nil if the states
+// differ in length.
+func encodeDelta(baseline, state []byte) []byte {
+	if len(baseline) != len(state) {
+		return nil
+	}
+
+	delta := make([]byte, 0)
+	for i := 0; i < len(state); {
+		if baseline[i] == state[i] {
+			i++
+			continue
+		}
+
+		end, gap := i+1, 0
+		for j := i + 1; j < len(state) && gap < minDeltaGap; j++ {
+			if baseline[j] == state[j] {
+				gap++
+				continue
+			}
+			end, gap = j+1, 0
+		}
+
+		delta = binary.AppendUvarint(delta, uint64(i))
+		delta = binary.AppendUvarint(delta, uint64(end-i))
+		delta = append(delta, state[i:end]...)
+		i = end
+	}
+
+	return delta
+}
+
+// transformMessage returns the transform of the GameObject for the client
+// receiving deltas. It is a delta against the transform the client
+// acknowledged last, or a full snapshot if there is no such transform or the
+// delta is not smaller.
+// The caller must hold the lock of the GameObjectManager.
+func transformMessage(senderIDByte []byte, gameObject *GameObject, client *Client) []byte {
+	header := encodeObjectID(gameObject.id)
+	header = binary.LittleEndian.AppendUint16(header, gameObject.transformSequence)
+
+	if sequence, baseline, ok := gameObject.baseline(client.id); ok {
+		delta := encodeDelta(baseline, gameObject.transform)
+		if delta != nil && len(delta)+2 < len(gameObject.transform) {
+			payload := binary.LittleEndian.AppendUint16(header, sequence)
+			return NewOutboundMessage(senderIDByte, TransformDelta, append(payload, delta...))
+		}
+	}
+
+	return NewOutboundMessage(senderIDByte, TransformSnapshot, append(header, gameObject.transform...))
+}
+
+// catchUpTransform returns the transform of the GameObject for the client
+// catching up with the room. The client receiving deltas gets it from
+// transformMessage so that it can acknowledge the sequence number.
+// The caller must hold the lock of the GameObjectManager.
+func catchUpTransform(senderIDByte []byte, gameObject *GameObject, client *Client) []byte {
+	if client.deltaTransforms.Load() {
+		return transformMessage(senderIDByte, gameObject, client)
+	}
+
+	payload := append(encodeObjectID(gameObject.id), gameObject.transform...)
+	return NewOutboundMessage(senderIDByte, Transform, payload)
+}
@@ -0,0 +1,212 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+var errInvalidDelta = errors.New("invalid delta")
+
+// applyDelta returns the state the delta is applied to the baseline, as the
+// clients receiving deltas do.
+func applyDelta(baseline, delta []byte) ([]byte, error) {
+	state := append([]byte{}, baseline...)
+	for len(delta) > 0 {
+		offset, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errInvalidDelta
+		}
+		delta = delta[n:]
+
+		length, n := binary.Uvarint(delta)
+		if n <= 0 {
+			return nil, errInvalidDelta
+		}
+		delta = delta[n:]
+
+		if offset+length > uint64(len(state)) || length > uint64(len(delta)) {
+			return nil, errInvalidDelta
+		}
+		copy(state[offset:], delta[:length])
+		delta = delta[length:]
+	}
+
+	return state, nil
+}
+
+func TestDelta(t *testing.T) {
+	baseline := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}
+	state := []byte{0, 9, 2, 3, 4, 5, 6, 7, 8, 8, 10, 12}
+
+	delta := encodeDelta(baseline, state)
+	if len(delta) >= len(state) {
+		t.Errorf("delta is not smaller %v", delta)
+	}
+
+	applied, err := applyDelta(baseline, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(applied, state) {
+		t.Errorf("invalid state get: %v, want: %v", applied, state)
+	}
+
+	if delta := encodeDelta(baseline, state[1:]); delta != nil {
+		t.Errorf("delta of different length %v", delta)
+	}
+	if _, err := applyDelta(baseline, []byte{10, 5, 0}); err == nil {
+		t.Error("invalid delta accepted")
+	}
+}
+
+func TestTransformHistory(t *testing.T) {
+	gameObject := &GameObject{}
+	first := gameObject.updateTransform([]byte{1}, time.Now())
+	second := gameObject.updateTransform([]byte{2}, time.Now())
+
+	if _, _, ok := gameObject.baseline(1); ok {
+		t.Error("baseline without ack")
+	}
+
+	if !gameObject.ackTransform(1, second) || !gameObject.ackTransform(1, first) {
+		t.Fatal("transform is not acknowledged")
+	}
+	if sequence, data, ok := gameObject.baseline(1); !ok || sequence != second || !bytes.Equal(data, []byte{2}) {
+		t.Errorf("invalid baseline %v %v %v", sequence, data, ok)
+	}
+
+	for i := 0; i < transformHistorySize; i++ {
+		gameObject.updateTransform([]byte{3}, time.Now())
+	}
+	if _, _, ok := gameObject.baseline(1); ok {
+		t.Error("baseline out of history")
+	}
+	if gameObject.ackTransform(1, first) {
+		t.Error("unknown transform acknowledged")
+	}
+}
+
+func TestSyncServiceDelta(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.skip(t, 1)
+	first.skip(t, 1)
+
+	client, err := room.clientManager.Get(second.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.deltaTransforms.Store(true)
+
+	instantiate := objectPayload(1, append([]byte{ownerExist}, "player"...)...)
+	if err := send(first.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, Instantiate, instantiate)
+	second.expect(t, first.id, Instantiate, instantiate)
+
+	sequence := func(seq ...uint16) []byte {
+		payload := objectPayload(1)
+		for _, s := range seq {
+			payload = binary.LittleEndian.AppendUint16(payload, s)
+		}
+		return payload
+	}
+
+	transform := bytes.Repeat([]byte{1}, 16)
+	if err := send(first.conn, append([]byte{OtherClients, Transform}, objectPayload(1, transform...)...)); err != nil {
+		t.Fatal(err)
+	}
+	second.expect(t, first.id, TransformSnapshot, append(sequence(1), transform...))
+
+	if err := send(second.conn, append([]byte{Server, AckTransform}, sequence(1)...)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		room.gameObjectManager.Lock()
+		_, _, ok := room.gameObjectManager.gameObjects[1].baseline(second.id)
+		room.gameObjectManager.Unlock()
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting ack")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	changed := append([]byte{}, transform...)
+	changed[4] = 2
+	if err := send(first.conn, append([]byte{OtherClients, Transform}, objectPayload(1, changed...)...)); err != nil {
+		t.Fatal(err)
+	}
+	second.expect(t, first.id, TransformDelta, append(sequence(2, 1), encodeDelta(transform, changed)...))
+}
+
+func TestSyncServiceDeltaLateJoin(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instantiate := objectPayload(1, append([]byte{ownerExist}, "player"...)...)
+	if err := send(first.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, Instantiate, instantiate)
+
+	transform := bytes.Repeat([]byte{1}, 16)
+	if err := send(first.conn, append([]byte{OtherClients, Transform}, objectPayload(1, transform...)...)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		room.gameObjectManager.Lock()
+		sequence := room.gameObjectManager.gameObjects[1].transformSequence
+		room.gameObjectManager.Unlock()
+		if sequence == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting transform")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	client, err := NewClient(room, serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.deltaTransforms.Store(true)
+	second, err := registerTestClient(room, client, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second.expect(t, first.id, NewConnect, nil)
+	second.expect(t, first.id, Instantiate, instantiate)
+	snapshot := append(binary.LittleEndian.AppendUint16(objectPayload(1), 1), transform...)
+	second.expect(t, first.id, TransformSnapshot, snapshot)
+}
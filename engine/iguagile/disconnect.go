@@ -0,0 +1,61 @@
+package iguagile
+
+import "fmt"
+
+// DisconnectReason is why a client is unregistered from the room.
+// The room notifies the other clients with ExitConnect carrying the reason
+// (1 byte).
+//
+// A client leaving the room sends Leave to Server. The session of a client
+// whose connection is closed without it is suspended for ResumeTimeout, and
+// the client is unregistered with DisconnectTimeout unless it resumes.
+type DisconnectReason byte
+
+// Disconnect reasons
+const (
+	// DisconnectLeft is when the client leaves with Leave, or closes or loses
+	// the connection while resuming is disabled.
+	DisconnectLeft DisconnectReason = iota
+
+	// DisconnectTimeout is when the client is silent for IdleTimeout or does
+	// not resume the session in time.
+	DisconnectTimeout
+
+	// DisconnectProtocolError is when the client sends a message the room
+	// can not process.
+	DisconnectProtocolError
+
+	// DisconnectKicked is when the client is kicked by Room.Kick.
+	DisconnectKicked
+
+	// DisconnectServerShutdown is when the server shuts down.
+	DisconnectServerShutdown
+
+	// DisconnectRoomClosed is when the room is closed.
+	DisconnectRoomClosed
+
+	// DisconnectSlowConsumer is when the client does not keep up with the
+	// messages and overflows the send queue.
+	DisconnectSlowConsumer
+)
+
+func (r DisconnectReason) String() string {
+	switch r {
+	case DisconnectLeft:
+		return "left"
+	case DisconnectTimeout:
+		return "timeout"
+	case DisconnectProtocolError:
+		return "protocol error"
+	case DisconnectKicked:
+		return "kicked"
+	case DisconnectServerShutdown:
+		return "server shutdown"
+	case DisconnectRoomClosed:
+		return "room closed"
+	case DisconnectSlowConsumer:
+		return "slow consumer"
+	default:
+		return fmt.Sprintf("DisconnectReason(%d)", byte(r))
+	}
+}
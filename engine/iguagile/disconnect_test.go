@@ -0,0 +1,111 @@
+package iguagile
+
+import (
+	"testing"
+	"time"
+)
+
+type disconnectTestService struct {
+	*RelayService
+	reasons chan DisconnectReason
+}
+
+func (s *disconnectTestService) OnUnregisterClient(_ int, reason DisconnectReason) error {
+	s.reasons <- reason
+	return nil
+}
+
+type disconnectTestServiceFactory struct {
+	service *disconnectTestService
+}
+
+func (f disconnectTestServiceFactory) Create(room *Room) (RoomService, error) {
+	f.service.RelayService = &RelayService{room: room}
+	return f.service, nil
+}
+
+func TestDisconnectReason(t *testing.T) {
+	service := &disconnectTestService{reasons: make(chan DisconnectReason, 2)}
+	room, err := newTestRoom(disconnectTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := room.Kick(second.id); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, second.id, ExitConnect, []byte{byte(DisconnectKicked)})
+	if reason := <-service.reasons; reason != DisconnectKicked {
+		t.Errorf("invalid reason get: %v, want: %v", reason, DisconnectKicked)
+	}
+
+	if err := room.Kick(second.id); err == nil {
+		t.Error("kicked client is kicked again")
+	}
+
+	third, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := room.clientManager.Get(third.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.closeSlowClient()
+	first.expect(t, third.id, ExitConnect, []byte{byte(DisconnectSlowConsumer)})
+	if reason := <-service.reasons; reason != DisconnectSlowConsumer {
+		t.Errorf("invalid reason get: %v, want: %v", reason, DisconnectSlowConsumer)
+	}
+
+	if err := room.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case reason := <-service.reasons:
+		if reason != DisconnectRoomClosed {
+			t.Errorf("invalid reason get: %v, want: %v", reason, DisconnectRoomClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting unregistration")
+	}
+}
+
+func TestLeave(t *testing.T) {
+	service := &disconnectTestService{reasons: make(chan DisconnectReason, 1)}
+	room, err := newTestRoom(disconnectTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.ResumeTimeout = time.Minute
+	room.server.MaxMissedMessages = 16
+
+	first, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client leaving is unregistered at once instead of being suspended.
+	if err := send(second.conn, []byte{Server, Leave}); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, second.id, ExitConnect, []byte{byte(DisconnectLeft)})
+	if reason := <-service.reasons; reason != DisconnectLeft {
+		t.Errorf("invalid reason get: %v, want: %v", reason, DisconnectLeft)
+	}
+	if room.clientManager.Exist(second.id) {
+		t.Error("client left is still registered")
+	}
+}
@@ -11,6 +11,71 @@ type GameObject struct {
 	owner        *Client
 	lifetime     byte
 	resourcePath []byte
+	transform    []byte
+	position     *Vector3
+	requesters   map[int]bool
+
+	transformSequence uint16
+	transformHistory  [transformHistorySize]transformState
+	acks              map[int]uint16
+}
+
+// GetID is getter for id.
+func (o *GameObject) GetID() int {
+	return o.id
+}
+
+// GetOwner is getter for owner.
+func (o *GameObject) GetOwner() *Client {
+	return o.owner
+}
+
+// GetResourcePath is getter for resourcePath.
+func (o *GameObject) GetResourcePath() []byte {
+	return o.resourcePath
+}
+
+// addRequester records the client requesting the ownership.
+func (o *GameObject) addRequester(clientID int) {
+	if o.requesters == nil {
+		o.requesters = make(map[int]bool)
+	}
+	o.requesters[clientID] = true
+}
+
+// removeRequester discards the ownership request and reports whether it existed.
+func (o *GameObject) removeRequester(clientID int) bool {
+	if !o.requesters[clientID] {
+		return false
+	}
+
+	delete(o.requesters, clientID)
+	return true
+}
+
+// changeOwner changes the owner and returns the ids of clients whose ownership
+// requests are discarded.
+func (o *GameObject) changeOwner(owner *Client) []int {
+	o.owner = owner
+	requesters := make([]int, 0, len(o.requesters))
+	for id := range o.requesters {
+		if owner == nil || id != owner.id {
+			requesters = append(requesters, id)
+		}
+	}
+	o.requesters = nil
+	return requesters
+}
+
+// GetTransform returns the latest transform sent by the owner.
+func (o *GameObject) GetTransform() []byte {
+	return o.transform
+}
+
+// GetPosition returns the latest position reported by the owner, or nil if
+// the position is unknown.
+func (o *GameObject) GetPosition() *Vector3 {
+	return o.position
 }
 
 // lifetime
@@ -22,6 +87,10 @@ const (
 // GameObjectManager manages GameObjects.
 type GameObjectManager struct {
 	gameObjects map[int]*GameObject
+
+	// The clients sent the GameObjects. The others do not receive the changes
+	// of the GameObjects until they are caught up with the room.
+	synced map[*Client]struct{}
 	*sync.Mutex
 }
 
@@ -29,6 +98,7 @@ type GameObjectManager struct {
 func NewGameObjectManager() *GameObjectManager {
 	return &GameObjectManager{
 		gameObjects: make(map[int]*GameObject),
+		synced:      make(map[*Client]struct{}),
 		Mutex:       &sync.Mutex{},
 	}
 }
@@ -76,4 +146,29 @@ func (m *GameObjectManager) GetAllGameObjects() map[int]*GameObject {
 // Clear all GameObjects.
 func (m *GameObjectManager) Clear() {
 	m.gameObjects = make(map[int]*GameObject)
+	m.synced = make(map[*Client]struct{})
+}
+
+// sync records that the room state is queued to the client, which receives
+// the changes of the GameObjects from now on.
+func (m *GameObjectManager) sync(client *Client) {
+	m.synced[client] = struct{}{}
+}
+
+func (m *GameObjectManager) unsync(client *Client) {
+	delete(m.synced, client)
+}
+
+func (m *GameObjectManager) isSynced(client *Client) bool {
+	_, ok := m.synced[client]
+	return ok
+}
+
+// syncedClients returns the clients caught up with the room.
+func (m *GameObjectManager) syncedClients() []*Client {
+	clients := make([]*Client, 0, len(m.synced))
+	for client := range m.synced {
+		clients = append(clients, client)
+	}
+	return clients
 }
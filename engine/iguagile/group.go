@@ -0,0 +1,139 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"sync"
+)
+
+// groupIDSize is the size of the group id.
+const groupIDSize = 2
+
+// GroupManager manages the groups in the room and their members.
+type GroupManager struct {
+	groups      map[int]map[int]*Client
+	memberships map[int]map[int]bool
+	*sync.Mutex
+}
+
+// NewGroupManager is GroupManager constructed.
+func NewGroupManager() *GroupManager {
+	return &GroupManager{
+		groups:      make(map[int]map[int]*Client),
+		memberships: make(map[int]map[int]bool),
+		Mutex:       &sync.Mutex{},
+	}
+}
+
+// Subscribe adds the client to the group.
+func (m *GroupManager) Subscribe(groupID int, client *Client) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.groups[groupID] == nil {
+		m.groups[groupID] = make(map[int]*Client)
+	}
+	m.groups[groupID][client.id] = client
+
+	if m.memberships[client.id] == nil {
+		m.memberships[client.id] = make(map[int]bool)
+	}
+	m.memberships[client.id][groupID] = true
+}
+
+// Unsubscribe removes the client from the group.
+func (m *GroupManager) Unsubscribe(groupID, clientID int) {
+	m.Lock()
+	defer m.Unlock()
+	m.unsubscribe(groupID, clientID)
+}
+
+func (m *GroupManager) unsubscribe(groupID, clientID int) {
+	delete(m.groups[groupID], clientID)
+	if len(m.groups[groupID]) == 0 {
+		delete(m.groups, groupID)
+	}
+
+	delete(m.memberships[clientID], groupID)
+	if len(m.memberships[clientID]) == 0 {
+		delete(m.memberships, clientID)
+	}
+}
+
+// RemoveClient removes the client from all groups.
+func (m *GroupManager) RemoveClient(clientID int) {
+	m.Lock()
+	defer m.Unlock()
+	for groupID := range m.memberships[clientID] {
+		m.unsubscribe(groupID, clientID)
+	}
+}
+
+// Members returns a snapshot of the members of the group.
+func (m *GroupManager) Members(groupID int) []*Client {
+	m.Lock()
+	defer m.Unlock()
+	members := make([]*Client, 0, len(m.groups[groupID]))
+	for _, client := range m.groups[groupID] {
+		members = append(members, client)
+	}
+	return members
+}
+
+// Groups returns the ids of the groups the client belongs to.
+func (m *GroupManager) Groups(clientID int) []int {
+	m.Lock()
+	defer m.Unlock()
+	groups := make([]int, 0, len(m.memberships[clientID]))
+	for groupID := range m.memberships[clientID] {
+		groups = append(groups, groupID)
+	}
+	return groups
+}
+
+// readGroupID reads the group id at the head of the payload.
+func readGroupID(payload []byte) (int, error) {
+	if len(payload) < groupIDSize {
+		return 0, ErrInvalidDataFormat
+	}
+
+	return int(binary.LittleEndian.Uint16(payload)), nil
+}
+
+// SendToGroup sends outbound message to the members of the group.
+func (r *Room) SendToGroup(senderID, groupID int, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.groupManager.Members(groupID) {
+		client.send(message, true)
+	}
+}
+
+// SendToOtherGroupMembers sends outbound message to the members of the group
+// other than the sender.
+func (r *Room) SendToOtherGroupMembers(senderID, groupID int, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.groupManager.Members(groupID) {
+		if client.id != senderID {
+			client.send(message, true)
+		}
+	}
+}
+
+// SendUnreliableToGroup sends outbound message to the members of the group
+// over the datagram transport where available.
+func (r *Room) SendUnreliableToGroup(senderID, groupID int, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.groupManager.Members(groupID) {
+		client.send(message, false)
+	}
+}
+
+// SendUnreliableToOtherGroupMembers sends outbound message to the members of
+// the group other than the sender over the datagram transport where available.
+func (r *Room) SendUnreliableToOtherGroupMembers(senderID, groupID int, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.groupManager.Members(groupID) {
+		if client.id != senderID {
+			client.send(message, false)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package iguagile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGroupManager(t *testing.T) {
+	manager := NewGroupManager()
+	first, second := &Client{id: 1}, &Client{id: 2}
+
+	manager.Subscribe(10, first)
+	manager.Subscribe(10, second)
+	manager.Subscribe(20, first)
+	if n := len(manager.Members(10)); n != 2 {
+		t.Errorf("invalid members %v", n)
+	}
+
+	manager.Unsubscribe(10, second.id)
+	if members := manager.Members(10); len(members) != 1 || members[0] != first {
+		t.Errorf("invalid members %v", members)
+	}
+
+	manager.RemoveClient(first.id)
+	if len(manager.groups) != 0 || len(manager.memberships) != 0 {
+		t.Errorf("groups remain %v %v", manager.groups, manager.memberships)
+	}
+}
+
+func TestSyncServiceGroup(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]*testClient, 3)
+	for i := range clients {
+		if clients[i], err = joinTestRoom(room); err != nil {
+			t.Fatal(err)
+		}
+		clients[i].skip(t, i)
+		for _, client := range clients[:i] {
+			client.skip(t, 1)
+		}
+	}
+	first, second, third := clients[0], clients[1], clients[2]
+
+	group := encodeClientID(7)
+	for _, client := range []*testClient{first, second} {
+		if err := send(client.conn, append([]byte{Server, JoinGroup}, group...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for len(room.groupManager.Members(7)) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	instantiate := objectPayload(1, append([]byte{ownerExist}, "player"...)...)
+	if err := send(first.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range clients {
+		client.expect(t, first.id, Instantiate, instantiate)
+	}
+
+	rpc := objectPayload(1, 1)
+	if err := send(first.conn, append(append([]byte{OtherGroupClients}, group...), append([]byte{RPC}, rpc...)...)); err != nil {
+		t.Fatal(err)
+	}
+	second.expect(t, first.id, RPC, rpc)
+
+	rpc = objectPayload(1, 2)
+	if err := send(first.conn, append(append([]byte{GroupClients}, group...), append([]byte{RPC}, rpc...)...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, RPC, rpc)
+	second.expect(t, first.id, RPC, rpc)
+
+	rpc = objectPayload(1, 3)
+	if err := send(first.conn, append([]byte{AllClients, RPC}, rpc...)); err != nil {
+		t.Fatal(err)
+	}
+	third.expect(t, first.id, RPC, rpc)
+
+	_ = second.conn.Close()
+	deadline := time.Now().Add(time.Second)
+	for len(room.groupManager.Members(7)) > 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("unregistered client remains in the group")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
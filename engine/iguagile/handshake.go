@@ -0,0 +1,126 @@
+package iguagile
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HandshakeStatus is the result of the handshake sent to the client.
+//
+// The server answers the handshake with a message of the status. If the
+// handshake succeeds, the status is followed by the client id (2 bytes), the
+// host id (2 bytes), the resume token (16 bytes) and the negotiated
+// CompressionID (1 byte). Otherwise the connection is closed after the status.
+//
+// The room id in the first message of the handshake can be followed by
+// options, each of which is the kind (1 byte), the length of the value
+// (1 byte) and the value. A disconnected client resumes the session with the
+// resume token option, followed by the application name and the version
+// without the password and the room token. The client offers CompressionIDs
+// in the order of preference with the compression option. The client receives
+// transforms as deltas with the delta transform option, which has no value.
+//
+// The client sends and receives messages larger than a frame with the
+// fragmentation option, which has no value. After the handshake, each frame of
+// the client starts with a flag (1 byte) counted in the size of the frame,
+// which is 1 if the rest of the message follows in the next frame and 0 if
+// the frame ends the message. Without the option, a frame is a message.
+type HandshakeStatus byte
+
+// Handshake statuses
+const (
+	HandshakeSucceeded HandshakeStatus = iota
+	HandshakeInvalidRequest
+	HandshakeRoomNotFound
+	HandshakeRoomFull
+	HandshakeInvalidApplicationName
+	HandshakeInvalidVersion
+	HandshakeInvalidPassword
+	HandshakeInvalidToken
+	HandshakeInternalError
+	HandshakeServerBusy
+	HandshakeServerClosed
+	HandshakeInvalidResumeToken
+)
+
+// ErrHandshakeTimeout is when the client does not complete the handshake in time.
+var ErrHandshakeTimeout = errors.New("handshake timeout")
+
+// ErrTooManyHandshakes is when pending handshakes exceed the limit.
+var ErrTooManyHandshakes = errors.New("too many pending handshakes")
+
+// HandshakeError is an error rejects the client in the handshake.
+type HandshakeError struct {
+	Status HandshakeStatus
+	Err    error
+}
+
+func (e *HandshakeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.Err
+}
+
+func newHandshakeError(status HandshakeStatus, err error) error {
+	return &HandshakeError{Status: status, Err: err}
+}
+
+// handshakeSucceeded returns a message tells the client its id, the host id,
+// the resume token and the compression.
+func handshakeSucceeded(client, host *Client, compression CompressionID) []byte {
+	message := make([]byte, 0, len(client.idByte)+len(host.idByte)+len(client.resumeToken)+2)
+	message = append(message, byte(HandshakeSucceeded))
+	message = append(message, client.idByte...)
+	message = append(message, host.idByte...)
+	message = append(message, client.resumeToken...)
+	return append(message, byte(compression))
+}
+
+// Kinds of the handshake options
+const (
+	handshakeOptionResumeToken byte = iota + 1
+	handshakeOptionCompression
+	handshakeOptionDeltaTransform
+	handshakeOptionFragmentation
+)
+
+type handshakeOptions struct {
+	resumeToken     []byte
+	compressions    []CompressionID
+	compression     CompressionID
+	deltaTransforms bool
+	fragmentation   bool
+}
+
+func parseHandshakeOptions(data []byte) (*handshakeOptions, error) {
+	options := &handshakeOptions{}
+	for len(data) > 0 {
+		if len(data) < 2 || len(data) < int(data[1])+2 {
+			return nil, fmt.Errorf("invalid handshake options %v", data)
+		}
+
+		kind, value := data[0], data[2:int(data[1])+2]
+		data = data[int(data[1])+2:]
+		switch kind {
+		case handshakeOptionResumeToken:
+			if len(value) != resumeTokenSize {
+				return nil, fmt.Errorf("invalid resume token %v", value)
+			}
+			options.resumeToken = append([]byte{}, value...)
+		case handshakeOptionCompression:
+			for _, id := range value {
+				options.compressions = append(options.compressions, CompressionID(id))
+			}
+		case handshakeOptionDeltaTransform:
+			options.deltaTransforms = true
+		case handshakeOptionFragmentation:
+			options.fragmentation = true
+		default:
+			return nil, fmt.Errorf("invalid handshake option %v", kind)
+		}
+	}
+
+	return options, nil
+}
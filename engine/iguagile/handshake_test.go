@@ -0,0 +1,122 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandshakeStatus(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 4)
+	binary.LittleEndian.PutUint32(id, roomID)
+	otherID := make([]byte, 4)
+	binary.LittleEndian.PutUint32(otherID, roomID+1)
+
+	full, err := newRoom(room.server, &RoomConfig{RoomID: roomID + 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.rooms.Store(full.config.RoomID, full)
+	fullID := make([]byte, 4)
+	binary.LittleEndian.PutUint32(fullID, roomID+2)
+	resumeID := append(append([]byte{}, id...), handshakeOptionResumeToken, resumeTokenSize)
+	resumeID = append(resumeID, make([]byte, resumeTokenSize)...)
+
+	testData := []struct {
+		request [][]byte
+		want    HandshakeStatus
+	}{
+		{[][]byte{{1, 2}}, HandshakeInvalidRequest},
+		{[][]byte{otherID}, HandshakeRoomNotFound},
+		{[][]byte{fullID}, HandshakeRoomFull},
+		{[][]byte{id, []byte("other")}, HandshakeInvalidApplicationName},
+		{[][]byte{id, []byte(appName), []byte("other")}, HandshakeInvalidVersion},
+		{[][]byte{id, []byte(appName), []byte(appVersion), []byte("other")}, HandshakeInvalidPassword},
+		{[][]byte{id, []byte(appName), []byte(appVersion), []byte(password), []byte("other")}, HandshakeInvalidToken},
+		{[][]byte{append(append([]byte{}, id...), 1)}, HandshakeInvalidRequest},
+		{[][]byte{resumeID, []byte(appName), []byte(appVersion)}, HandshakeInvalidResumeToken},
+		{[][]byte{resumeID, []byte("other")}, HandshakeInvalidApplicationName},
+		{[][]byte{resumeID, []byte(appName), []byte("other")}, HandshakeInvalidVersion},
+	}
+
+	for _, v := range testData {
+		serverConn, clientConn := net.Pipe()
+		result := make(chan error, 1)
+		go func() {
+			result <- room.server.Serve(serverConn)
+		}()
+
+		for _, data := range v.request {
+			if err := send(clientConn, data); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		buf := make([]byte, maxMessageSize)
+		n, err := receive(clientConn, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != 1 || HandshakeStatus(buf[0]) != v.want {
+			t.Errorf("invalid status get: %v, want: %v", buf[:n], v.want)
+		}
+
+		var handshakeErr *HandshakeError
+		if err := <-result; !errors.As(err, &handshakeErr) || handshakeErr.Status != v.want {
+			t.Errorf("invalid error %v", err)
+		}
+
+		_ = clientConn.Close()
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.HandshakeTimeout = time.Millisecond * 50
+	room.server.MaxPendingHandshakes = 1
+
+	stalled, _ := net.Pipe()
+	result := make(chan error, 1)
+	go func() {
+		result <- room.server.Serve(stalled)
+	}()
+
+	for atomic.LoadInt64(&room.server.pendingHandshakes) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		_ = room.server.Serve(serverConn)
+	}()
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(clientConn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || HandshakeStatus(buf[0]) != HandshakeServerBusy {
+		t.Errorf("invalid status get: %v, want: %v", buf[:n], HandshakeServerBusy)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, ErrHandshakeTimeout) {
+			t.Errorf("invalid error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("stalled handshake is not dropped")
+	}
+}
@@ -0,0 +1,58 @@
+package iguagile
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// HostElection elects a new host from the clients.
+type HostElection func(clients []*Client) *Client
+
+// ErrNoHostCandidate is when there is no client to be the host.
+var ErrNoHostCandidate = errors.New("no host candidate")
+
+// ElectLongestConnected elects the client connected for the longest time.
+func ElectLongestConnected(clients []*Client) *Client {
+	if len(clients) == 0 {
+		return nil
+	}
+
+	sortByConnectedAt(clients)
+	return clients[0]
+}
+
+// ElectLowestLatency elects the client with the lowest latency.
+// Clients with the same latency are ordered by the connection time.
+// A zero latency is not measured yet, so those clients are ranked last.
+func ElectLowestLatency(clients []*Client) *Client {
+	if len(clients) == 0 {
+		return nil
+	}
+
+	sortByConnectedAt(clients)
+	host := clients[0]
+	for _, client := range clients[1:] {
+		if lowerLatency(client.Latency(), host.Latency()) {
+			host = client
+		}
+	}
+
+	return host
+}
+
+func lowerLatency(a, b time.Duration) bool {
+	if a == 0 || b == 0 {
+		return b == 0 && a != 0
+	}
+	return a < b
+}
+
+func sortByConnectedAt(clients []*Client) {
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].connectedAt.Equal(clients[j].connectedAt) {
+			return clients[i].id < clients[j].id
+		}
+		return clients[i].connectedAt.Before(clients[j].connectedAt)
+	})
+}
@@ -0,0 +1,108 @@
+package iguagile
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostElection(t *testing.T) {
+	now := time.Now()
+	clients := []*Client{
+		{id: 3, connectedAt: now.Add(time.Second), latency: int64(time.Millisecond * 10)},
+		{id: 2, connectedAt: now, latency: int64(time.Millisecond * 30)},
+		{id: 1, connectedAt: now, latency: int64(time.Millisecond * 20)},
+	}
+
+	if host := ElectLongestConnected(clients); host.id != 1 {
+		t.Errorf("invalid host get: %v, want: %v", host.id, 1)
+	}
+
+	if host := ElectLowestLatency(clients); host.id != 3 {
+		t.Errorf("invalid host get: %v, want: %v", host.id, 3)
+	}
+
+	// The latency of a client not measured yet is unknown rather than zero.
+	clients = append(clients, &Client{id: 4, connectedAt: now.Add(time.Second * 2)})
+	if host := ElectLowestLatency(clients); host.id != 3 {
+		t.Errorf("invalid host get: %v, want: %v", host.id, 3)
+	}
+
+	unmeasured := []*Client{
+		{id: 2, connectedAt: now.Add(time.Second)},
+		{id: 1, connectedAt: now},
+	}
+	if host := ElectLowestLatency(unmeasured); host.id != 1 {
+		t.Errorf("invalid host get: %v, want: %v", host.id, 1)
+	}
+
+	if host := ElectLongestConnected(nil); host != nil {
+		t.Errorf("invalid host get: %v, want: nil", host.id)
+	}
+}
+
+func TestMigrateHost(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, guest.id, NewConnect, nil)
+	guest.expect(t, host.id, NewConnect, nil)
+
+	if err := host.conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	guest.expect(t, guest.id, MigrateHost, nil)
+	guest.expect(t, host.id, ExitConnect, []byte{byte(DisconnectLeft)})
+
+	if id := room.GetHost().GetID(); id != guest.id {
+		t.Errorf("invalid host get: %v, want: %v", id, guest.id)
+	}
+}
+
+func TestMigrateHostSkipsSuspended(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.ResumeTimeout = time.Minute
+	room.server.MaxMissedMessages = 16
+
+	host, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	suspended, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest.expect(t, host.id, NewConnect, nil)
+	guest.expect(t, suspended.id, NewConnect, nil)
+
+	_ = suspended.conn.Close()
+	waitSuspended(t, room, suspended.id)
+
+	// The suspended client connected earlier, but can not act as the host.
+	if err := send(host.conn, []byte{Server, Leave}); err != nil {
+		t.Fatal(err)
+	}
+	guest.expect(t, guest.id, MigrateHost, nil)
+
+	if id := room.GetHost().GetID(); id != guest.id {
+		t.Errorf("invalid host get: %v, want: %v", id, guest.id)
+	}
+}
@@ -0,0 +1,149 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Vector3 is a position in the world.
+type Vector3 struct {
+	X, Y, Z float32
+}
+
+// InterestArea is the area of interest of a client.
+// Clients without InterestArea are interested in the whole world.
+type InterestArea interface {
+	Contains(position Vector3) bool
+}
+
+// SphereArea is the area within the radius from the center.
+type SphereArea struct {
+	Center Vector3
+	Radius float32
+}
+
+// Contains for implement InterestArea.
+func (a SphereArea) Contains(position Vector3) bool {
+	dx, dy, dz := position.X-a.Center.X, position.Y-a.Center.Y, position.Z-a.Center.Z
+	return dx*dx+dy*dy+dz*dz <= a.Radius*a.Radius
+}
+
+// GridArea is the grid cell and its neighbors.
+type GridArea struct {
+	CellSize float32
+	Cell     [3]int32
+}
+
+// Contains for implement InterestArea.
+func (a GridArea) Contains(position Vector3) bool {
+	for i, v := range []float32{position.X, position.Y, position.Z} {
+		cell := int64(math.Floor(float64(v / a.CellSize)))
+		if d := cell - int64(a.Cell[i]); d < -1 || d > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// Kinds of the interest areas
+const (
+	sphereArea byte = iota
+	gridArea
+)
+
+// parseInterestArea parses the area reported by the client.
+//
+//	sphere  kind (1 byte) | center x, y, z (float32) | radius (float32)
+//	grid    kind (1 byte) | cell size (float32) | cell x, y, z (int32)
+//
+// An empty payload clears the area.
+func parseInterestArea(payload []byte) (InterestArea, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+
+	if len(payload) != 17 {
+		return nil, ErrInvalidDataFormat
+	}
+
+	values := make([]uint32, 4)
+	for i := range values {
+		values[i] = binary.LittleEndian.Uint32(payload[1+i*4:])
+	}
+
+	switch payload[0] {
+	case sphereArea:
+		return SphereArea{
+			Center: Vector3{math.Float32frombits(values[0]), math.Float32frombits(values[1]), math.Float32frombits(values[2])},
+			Radius: math.Float32frombits(values[3]),
+		}, nil
+	case gridArea:
+		cellSize := math.Float32frombits(values[0])
+		if cellSize <= 0 {
+			return nil, fmt.Errorf("invalid cell size %v", cellSize)
+		}
+		return GridArea{
+			CellSize: cellSize,
+			Cell:     [3]int32{int32(values[1]), int32(values[2]), int32(values[3])},
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid interest area %v", payload[0])
+	}
+}
+
+// parsePosition parses x, y and z (float32).
+func parsePosition(payload []byte) (Vector3, error) {
+	if len(payload) != 12 {
+		return Vector3{}, ErrInvalidDataFormat
+	}
+
+	return Vector3{
+		X: math.Float32frombits(binary.LittleEndian.Uint32(payload)),
+		Y: math.Float32frombits(binary.LittleEndian.Uint32(payload[4:])),
+		Z: math.Float32frombits(binary.LittleEndian.Uint32(payload[8:])),
+	}, nil
+}
+
+// Interest returns the area of interest of the client.
+func (c *Client) Interest() InterestArea {
+	c.interestMutex.Lock()
+	defer c.interestMutex.Unlock()
+	return c.interest
+}
+
+// SetInterest updates the area of interest of the client.
+func (c *Client) SetInterest(area InterestArea) {
+	c.interestMutex.Lock()
+	c.interest = area
+	c.interestMutex.Unlock()
+}
+
+// isInterested reports whether the position is in the area of interest.
+func (c *Client) isInterested(position Vector3) bool {
+	area := c.Interest()
+	return area == nil || area.Contains(position)
+}
+
+// SendToInterested sends outbound message to other clients whose area of
+// interest includes the position.
+func (r *Room) SendToInterested(senderID int, position Vector3, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.clientManager.Clients() {
+		if client.id != senderID && client.isInterested(position) {
+			client.send(message, true)
+		}
+	}
+}
+
+// SendUnreliableToInterested sends outbound message to other clients whose
+// area of interest includes the position over the datagram transport where
+// available.
+func (r *Room) SendUnreliableToInterested(senderID int, position Vector3, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.clientManager.Clients() {
+		if client.id != senderID && client.isInterested(position) {
+			client.send(message, false)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+func float32Payload(kind byte, values ...float32) []byte {
+	payload := []byte{kind}
+	for _, v := range values {
+		payload = binary.LittleEndian.AppendUint32(payload, math.Float32bits(v))
+	}
+	return payload
+}
+
+func TestInterestArea(t *testing.T) {
+	sphere, err := parseInterestArea(float32Payload(sphereArea, 1, 2, 3, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sphere.Contains(Vector3{1, 2, 8}) || sphere.Contains(Vector3{1, 2, 9}) {
+		t.Errorf("invalid sphere area %v", sphere)
+	}
+
+	grid := []byte{gridArea}
+	grid = binary.LittleEndian.AppendUint32(grid, math.Float32bits(10))
+	for _, cell := range []int32{1, -1, 0} {
+		grid = binary.LittleEndian.AppendUint32(grid, uint32(cell))
+	}
+	area, err := parseInterestArea(grid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !area.Contains(Vector3{29, -20, 5}) || area.Contains(Vector3{30, -20, 5}) || area.Contains(Vector3{5, -21, 5}) {
+		t.Errorf("invalid grid area %v", area)
+	}
+
+	if area, err := parseInterestArea(nil); area != nil || err != nil {
+		t.Errorf("empty area is not cleared %v %v", area, err)
+	}
+	if _, err := parseInterestArea([]byte{gridArea, 0}); err == nil {
+		t.Error("invalid area accepted")
+	}
+}
+
+func (c *testClient) skip(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.messages:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting message")
+		}
+	}
+}
+
+func TestSyncServiceInterest(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]*testClient, 3)
+	for i := range clients {
+		if clients[i], err = joinTestRoom(room); err != nil {
+			t.Fatal(err)
+		}
+		clients[i].skip(t, i)
+		for _, client := range clients[:i] {
+			client.skip(t, 1)
+		}
+	}
+	first, second, third := clients[0], clients[1], clients[2]
+
+	setInterest := func(client *testClient, x float32) {
+		payload := float32Payload(sphereArea, x, 0, 0, 10)
+		if err := send(client.conn, append([]byte{Server, Interest}, payload...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setInterest(second, 0)
+	setInterest(third, 100)
+	for _, client := range []*testClient{second, third} {
+		c, err := room.clientManager.Get(client.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for c.Interest() == nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	instantiate := objectPayload(1, append([]byte{ownerExist}, "player"...)...)
+	if err := send(first.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+	for _, client := range clients {
+		client.expect(t, first.id, Instantiate, instantiate)
+	}
+
+	position := objectPayload(1, float32Payload(0, 1, 0, 0)[1:]...)
+	if err := send(first.conn, append([]byte{Server, Position}, position...)); err != nil {
+		t.Fatal(err)
+	}
+
+	transform := objectPayload(1, 1, 2, 3)
+	if err := send(first.conn, append([]byte{OtherClients, Transform}, transform...)); err != nil {
+		t.Fatal(err)
+	}
+	second.expect(t, first.id, Transform, transform)
+
+	rpc := objectPayload(1, 4)
+	if err := send(first.conn, append([]byte{AllClients, RPC}, rpc...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, RPC, rpc)
+	second.expect(t, first.id, RPC, rpc)
+
+	setInterest(third, 5)
+	third.expect(t, first.id, Transform, transform)
+}
@@ -0,0 +1,173 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// Size of the times in the ping messages, that are unix time in nanoseconds.
+const timestampSize = 8
+
+// Number of times the idle clients are checked within IdleTimeout.
+const idleChecks = 4
+
+// heartbeatStart pings the client every PingInterval, and closes the
+// connection of the client silent for IdleTimeout, until the session of done
+// ends.
+//
+// Ping carries a sequence number (2 bytes) and the server time. The client
+// answers with Pong sent to Server carrying the sequence number, optionally
+// followed by the client time. Only the answer to the latest ping is measured.
+func (c *Client) heartbeatStart(done chan struct{}) {
+	c.touch()
+
+	var pings, checks <-chan time.Time
+	if interval := c.room.server.PingInterval; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		pings = ticker.C
+	}
+
+	timeout := c.room.server.IdleTimeout
+	if timeout > 0 {
+		ticker := time.NewTicker(timeout / idleChecks)
+		defer ticker.Stop()
+		checks = ticker.C
+	}
+
+	if pings == nil && checks == nil {
+		return
+	}
+
+	for {
+		select {
+		case <-pings:
+			c.ping()
+		case <-checks:
+			if idle := time.Since(c.lastReceived()); idle >= timeout && c.isCurrentSession(done) {
+				c.room.log.Printf("client %v is idle for %v\n", c.id, idle)
+				c.room.CloseConnection(c, DisconnectTimeout)
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// touch records that a message is received from the client.
+func (c *Client) touch() {
+	atomic.StoreInt64(&c.received, time.Now().UnixNano())
+}
+
+func (c *Client) lastReceived() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.received))
+}
+
+func (c *Client) ping() {
+	c.pingMutex.Lock()
+	c.pingSequence++
+	c.pingSentAt = time.Now()
+	payload := binary.LittleEndian.AppendUint16(nil, c.pingSequence)
+	payload = appendTimestamp(payload, c.pingSentAt)
+	c.pingMutex.Unlock()
+
+	c.Send(NewOutboundMessage(serverIDByte, Ping, payload))
+}
+
+// receivePing handles Ping and Pong sent to Server if PingInterval is set,
+// and reports whether the message is one of them.
+func (c *Client) receivePing(message []byte) (bool, error) {
+	if c.room.server.PingInterval <= 0 || len(message) < 2 || message[0] != Server {
+		return false, nil
+	}
+
+	switch message[1] {
+	case Ping:
+		c.answerPing(time.Now(), message[2:])
+		return true, nil
+	case Pong:
+		return true, c.pong(message[2:])
+	default:
+		return false, nil
+	}
+}
+
+// answerPing answers the ping of the client with Pong carrying the server
+// time the ping is received and the server time the answer is sent, followed
+// by the payload of the ping. The client estimates the offset of its clock
+// from them NTP-style.
+func (c *Client) answerPing(received time.Time, payload []byte) {
+	pong := appendTimestamp(nil, received)
+	pong = appendTimestamp(pong, time.Now())
+	c.Send(NewOutboundMessage(serverIDByte, Pong, append(pong, payload...)))
+}
+
+// pong measures the round trip time of the ping answered, and the clock
+// offset if the client time is given.
+func (c *Client) pong(payload []byte) error {
+	if len(payload) < 2 {
+		return ErrInvalidDataFormat
+	}
+
+	c.pingMutex.Lock()
+	defer c.pingMutex.Unlock()
+	if c.pingSentAt.IsZero() || binary.LittleEndian.Uint16(payload) != c.pingSequence {
+		return nil
+	}
+
+	rtt := time.Since(c.pingSentAt)
+	if last := c.RTT(); last > 0 {
+		// Smoothed like the interarrival jitter of RTP.
+		d := rtt - last
+		if d < 0 {
+			d = -d
+		}
+		jitter := c.Jitter()
+		atomic.StoreInt64(&c.jitter, int64(jitter+(d-jitter)/16))
+	}
+	atomic.StoreInt64(&c.rtt, int64(rtt))
+	c.SetLatency(rtt / 2)
+
+	if len(payload) >= 2+timestampSize {
+		// The client is assumed to answer half of the round trip after the
+		// ping is sent.
+		clientTime := readTimestamp(payload[2:])
+		offset := clientTime.Sub(c.pingSentAt.Add(rtt / 2))
+		atomic.StoreInt64(&c.clockOffset, int64(offset))
+	}
+
+	c.pingSentAt = time.Time{}
+	return nil
+}
+
+func appendTimestamp(b []byte, t time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(b, uint64(t.UnixNano()))
+}
+
+func readTimestamp(b []byte) time.Time {
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(b)))
+}
+
+// RTT returns the round trip time to the client measured last. It is zero
+// until the client answers a ping.
+func (c *Client) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+// Jitter returns the smoothed variation of the RTT.
+func (c *Client) Jitter() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.jitter))
+}
+
+// ClockOffset returns how far the clock of the client is ahead of the server.
+// It is zero until the client answers a ping with its time.
+func (c *Client) ClockOffset() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.clockOffset))
+}
+
+// ServerTime converts the time of the client to the server time.
+func (c *Client) ServerTime(clientTime time.Time) time.Time {
+	return clientTime.Add(-c.ClockOffset())
+}
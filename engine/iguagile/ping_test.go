@@ -0,0 +1,208 @@
+package iguagile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.PingInterval = time.Millisecond * 10
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := room.clientManager.Get(client.id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pings answered late are superseded, so all pings are answered until
+	// one is measured.
+	deadline := time.After(time.Second)
+	for c.RTT() == 0 {
+		select {
+		case message := <-client.messages:
+			data, err := NewOutBoundData(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data.MessageType != Ping || len(data.Payload) != 2+timestampSize {
+				t.Fatalf("invalid ping %v", message)
+			}
+
+			pong := appendTimestamp(append([]byte{Server, Pong}, data.Payload[:2]...), time.Now().Add(time.Hour))
+			if err := send(client.conn, pong); err != nil {
+				t.Fatal(err)
+			}
+		case <-deadline:
+			t.Fatal("rtt is not measured")
+		}
+	}
+
+	if offset := c.ClockOffset(); offset < time.Hour-time.Second || offset > time.Hour+time.Second {
+		t.Errorf("invalid clock offset %v", offset)
+	}
+	if c.Latency() != c.RTT()/2 {
+		t.Errorf("invalid latency get: %v, want: %v", c.Latency(), c.RTT()/2)
+	}
+}
+
+func TestAnswerPing(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.PingInterval = time.Hour
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sentAt := time.Now()
+	if err := send(client.conn, append([]byte{Server, Ping}, 1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-client.messages:
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.MessageType != Pong || len(data.Payload) != 2*timestampSize+3 {
+			t.Fatalf("invalid pong %v", message)
+		}
+
+		received, answered := readTimestamp(data.Payload), readTimestamp(data.Payload[timestampSize:])
+		if received.Before(sentAt.Add(-time.Second)) || answered.Before(received) || time.Since(answered) < 0 {
+			t.Errorf("invalid server time %v %v", received, answered)
+		}
+		if payload := data.Payload[2*timestampSize:]; payload[0] != 1 || payload[2] != 3 {
+			t.Errorf("invalid ping payload %v", payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting pong")
+	}
+}
+
+func TestAnswerPingWithoutPingInterval(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pong is ignored while the server does not ping.
+	if err := send(client.conn, []byte{Server, Pong, 1, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(client.conn, []byte{Server, Ping, 1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-client.messages:
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.MessageType != Pong || len(data.Payload) != 2*timestampSize+3 {
+			t.Fatalf("invalid pong %v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting pong")
+	}
+
+	if !room.clientManager.Exist(client.id) {
+		t.Error("client pinging is disconnected")
+	}
+}
+
+func TestPingDisabled(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relayed data looking like a ping reaches the service.
+	data := []byte{Server, Ping, 1}
+	if err := send(client.conn, data); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-client.messages:
+		if !bytes.Equal(message, data) {
+			t.Errorf("invalid message get: %v, want: %v", message, data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting relayed message")
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	service := &disconnectTestService{reasons: make(chan DisconnectReason, 1)}
+	room, err := newTestRoom(disconnectTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.IdleTimeout = time.Millisecond * 100
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages keep the client alive.
+	for i := 0; i < 4; i++ {
+		time.Sleep(time.Millisecond * 50)
+		if err := send(client.conn, []byte{Server, Pong, 0, 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case reason := <-service.reasons:
+		t.Fatalf("active client is closed %v", reason)
+	default:
+	}
+
+	select {
+	case reason := <-service.reasons:
+		if reason != DisconnectTimeout {
+			t.Errorf("invalid reason get: %v, want: %v", reason, DisconnectTimeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("idle client is not closed")
+	}
+
+	// The messages keeping the client alive are relayed back before closing.
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-client.messages:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("connection of idle client is not closed")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package iguagile
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 	"os"
@@ -99,15 +100,25 @@ func receive(reader io.Reader, buf []byte) (int, error) {
 	return reader.Read(buf[:int(size)])
 }
 
-func verify(writer io.Writer) error {
+func verify(conn io.ReadWriter) error {
 	buf := make([]byte, 4)
 	binary.LittleEndian.PutUint32(buf[:], roomID)
 	for _, data := range [][]byte{buf, []byte(appName), []byte(appVersion), []byte(password), roomToken} {
-		if err := send(writer, data); err != nil {
+		if err := send(conn, data); err != nil {
 			return err
 		}
 	}
 
+	buf = make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		return err
+	}
+
+	if n != 6+resumeTokenSize || buf[0] != byte(HandshakeSucceeded) {
+		return fmt.Errorf("handshake failed %v", buf[:n])
+	}
+
 	return nil
 }
 
@@ -0,0 +1,253 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum payload size of a reliable datagram.
+	maxReliablePayloadSize = 1200
+
+	// Maximum number of reliable datagrams waiting for the ack.
+	reliableWindowSize = 256
+
+	// Maximum size of the payloads received and not read yet. Datagrams are
+	// not acknowledged beyond it, so the peer retransmits them.
+	maxReadBufferSize = reliableWindowSize * maxReliablePayloadSize
+
+	retransmissionInterval = time.Millisecond * 100
+	maxRetransmissions     = 50
+)
+
+// ErrConnectionClosed is when the connection is already closed.
+var ErrConnectionClosed = errors.New("connection closed")
+
+// ErrRetransmissionExceeded is when the peer does not acknowledge datagrams.
+var ErrRetransmissionExceeded = errors.New("retransmission exceeded")
+
+type reliableDatagram struct {
+	data            []byte
+	sentAt          time.Time
+	retransmissions int
+}
+
+// reliableConn is a reliable and ordered stream over the datagram transport.
+//
+// Written data is split into datagrams of kind datagramReliable with a
+// sequence number followed by the payload. The receiver answers each datagram
+// with datagramAck and the sequence number, and reads the payloads in order.
+// Datagrams not acknowledged in retransmissionInterval are retransmitted.
+type reliableConn struct {
+	addr      net.Addr
+	transport *datagramTransport
+
+	mutex           *sync.Mutex
+	cond            *sync.Cond
+	sendSequence    uint16
+	unacked         map[uint16]*reliableDatagram
+	receiveSequence uint16
+	pending         map[uint16][]byte
+	readBuffer      []byte
+	closed          bool
+}
+
+func newReliableConn(transport *datagramTransport, addr net.Addr) *reliableConn {
+	mutex := &sync.Mutex{}
+	conn := &reliableConn{
+		addr:      addr,
+		transport: transport,
+		mutex:     mutex,
+		cond:      sync.NewCond(mutex),
+		unacked:   make(map[uint16]*reliableDatagram),
+		pending:   make(map[uint16][]byte),
+	}
+
+	go conn.retransmitStart()
+	return conn
+}
+
+// Read reads the payloads received in order.
+func (c *reliableConn) Read(buf []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.readBuffer) == 0 && !c.closed {
+		c.cond.Wait()
+	}
+
+	if len(c.readBuffer) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, c.readBuffer)
+	c.readBuffer = c.readBuffer[n:]
+	return n, nil
+}
+
+// Write sends the data reliably. It blocks while the window is full.
+func (c *reliableConn) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		size := len(data) - written
+		if size > maxReliablePayloadSize {
+			size = maxReliablePayloadSize
+		}
+
+		c.mutex.Lock()
+		for len(c.unacked) >= reliableWindowSize && !c.closed {
+			c.cond.Wait()
+		}
+
+		if c.closed {
+			c.mutex.Unlock()
+			return written, ErrConnectionClosed
+		}
+
+		datagram := make([]byte, sequenceSize+1+size)
+		datagram[0] = datagramReliable
+		binary.LittleEndian.PutUint16(datagram[1:], c.sendSequence)
+		copy(datagram[sequenceSize+1:], data[written:written+size])
+		c.unacked[c.sendSequence] = &reliableDatagram{data: datagram, sentAt: time.Now()}
+		c.sendSequence++
+		c.mutex.Unlock()
+
+		if _, err := c.transport.conn.WriteTo(datagram, c.addr); err != nil {
+			return written, err
+		}
+
+		written += size
+	}
+
+	return written, nil
+}
+
+// Close closes the connection and notifies the peer.
+func (c *reliableConn) Close() error {
+	if !c.shutdown() {
+		return nil
+	}
+
+	_, err := c.transport.conn.WriteTo([]byte{datagramClose}, c.addr)
+	return err
+}
+
+// shutdown closes the connection and reports whether it was open.
+func (c *reliableConn) shutdown() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false
+	}
+
+	c.closed = true
+	c.cond.Broadcast()
+	c.transport.conns.Delete(c.addr.String())
+	return true
+}
+
+func (c *reliableConn) receive(data []byte) error {
+	switch data[0] {
+	case datagramClose:
+		c.shutdown()
+		return nil
+	case datagramAck:
+		if len(data) < sequenceSize+1 {
+			return ErrInvalidDataFormat
+		}
+
+		c.mutex.Lock()
+		delete(c.unacked, binary.LittleEndian.Uint16(data[1:]))
+		c.cond.Broadcast()
+		c.mutex.Unlock()
+		return nil
+	}
+
+	if len(data) < sequenceSize+1 || len(data) > sequenceSize+1+maxReliablePayloadSize {
+		return ErrInvalidDataFormat
+	}
+
+	sequence := binary.LittleEndian.Uint16(data[1:])
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	old := sequenceLess(sequence, c.receiveSequence)
+	if !old && len(c.readBuffer) >= maxReadBufferSize {
+		return nil
+	}
+
+	ack := []byte{datagramAck, data[1], data[2]}
+	if _, err := c.transport.conn.WriteTo(ack, c.addr); err != nil {
+		return err
+	}
+
+	if old || sequence-c.receiveSequence >= reliableWindowSize {
+		return nil
+	}
+
+	payload := make([]byte, len(data)-sequenceSize-1)
+	copy(payload, data[sequenceSize+1:])
+	c.pending[sequence] = payload
+	for {
+		payload, ok := c.pending[c.receiveSequence]
+		if !ok {
+			break
+		}
+
+		delete(c.pending, c.receiveSequence)
+		c.readBuffer = append(c.readBuffer, payload...)
+		c.receiveSequence++
+	}
+
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *reliableConn) retransmitStart() {
+	ticker := time.NewTicker(retransmissionInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return
+		}
+
+		now := time.Now()
+		exceeded := false
+		datagrams := make([][]byte, 0)
+		for _, datagram := range c.unacked {
+			if now.Sub(datagram.sentAt) < retransmissionInterval {
+				continue
+			}
+
+			if datagram.retransmissions >= maxRetransmissions {
+				exceeded = true
+				break
+			}
+
+			datagram.retransmissions++
+			datagram.sentAt = now
+			datagrams = append(datagrams, datagram.data)
+		}
+		c.mutex.Unlock()
+
+		if exceeded {
+			c.transport.server.logger.Println(ErrRetransmissionExceeded, c.addr)
+			_ = c.Close()
+			return
+		}
+
+		for _, datagram := range datagrams {
+			if _, err := c.transport.conn.WriteTo(datagram, c.addr); err != nil {
+				c.transport.server.logger.Println(err)
+			}
+		}
+	}
+}
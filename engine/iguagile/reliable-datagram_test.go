@@ -0,0 +1,218 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSequenceLess(t *testing.T) {
+	testData := []struct {
+		a, b uint16
+		want bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{1, 1, false},
+		{65535, 0, true},
+		{0, 65535, false},
+	}
+
+	for _, v := range testData {
+		if got := sequenceLess(v.a, v.b); got != v.want {
+			t.Errorf("sequenceLess(%v, %v) get: %v, want: %v", v.a, v.b, got, v.want)
+		}
+	}
+}
+
+func TestReliableDatagram(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = serverConn.Close() }()
+
+	go func() {
+		_ = room.server.ServeDatagram(serverConn)
+	}()
+
+	clientConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = clientConn.Close() }()
+
+	transport := newDatagramTransport(room.server, clientConn)
+	conn := newReliableConn(transport, serverConn.LocalAddr())
+	transport.conns.Store(serverConn.LocalAddr().String(), conn)
+	defer func() { _ = conn.Close() }()
+
+	unreliable := make(chan []byte, 16)
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, _, err := clientConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			if buf[0] == datagramData {
+				unreliable <- append([]byte{}, buf[sequenceSize+1:n]...)
+				continue
+			}
+
+			if err := conn.receive(buf[:n]); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	if err := verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	instantiate := objectPayload(1, ownerExist)
+	if err := send(conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := NewOutBoundData(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.MessageType != Instantiate || !bytes.Equal(data.Payload, instantiate) {
+		t.Fatalf("invalid instantiate %v", buf[:n])
+	}
+	clientID := int(binary.LittleEndian.Uint16(data.ID))
+
+	// The rpc is larger than a reliable datagram.
+	rpc := objectPayload(1, bytes.Repeat([]byte{1, 2, 3}, maxReliablePayloadSize)...)
+	if err := send(conn, append([]byte{AllClients, RPC}, rpc...)); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = (&Client{conn: conn}).read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := NewOutboundMessage(encodeClientID(clientID), RPC, rpc)
+	if !bytes.Equal(buf[:n], want) {
+		t.Errorf("invalid rpc length get: %v, want: %v", n, len(want))
+	}
+
+	transform := objectPayload(1, 1, 2, 3)
+	datagram := append([]byte{datagramData, 0, 0, AllClients, Transform}, transform...)
+	if _, err := clientConn.WriteTo(datagram, serverConn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-unreliable:
+		want := NewOutboundMessage(encodeClientID(clientID), Transform, transform)
+		if !bytes.Equal(message, want) {
+			t.Errorf("invalid transform get: %v, want: %v", message, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting transform")
+	}
+}
+
+func TestReliableReadBufferLimit(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = packetConn.Close() }()
+
+	transport := newDatagramTransport(room.server, packetConn)
+	conn := newReliableConn(transport, packetConn.LocalAddr())
+	defer func() { _ = conn.Close() }()
+
+	datagram := func(sequence uint16, size int) []byte {
+		data := binary.LittleEndian.AppendUint16([]byte{datagramReliable}, sequence)
+		return append(data, make([]byte, size)...)
+	}
+
+	if err := conn.receive(datagram(0, maxReliablePayloadSize+1)); err == nil {
+		t.Error("too large datagram accepted")
+	}
+
+	for i := 0; i < reliableWindowSize*2; i++ {
+		if err := conn.receive(datagram(uint16(i), maxReliablePayloadSize)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	if len(conn.readBuffer) > maxReadBufferSize {
+		t.Errorf("read buffer exceeds the limit %v", len(conn.readBuffer))
+	}
+}
+
+func TestDatagramHandshakeRequireTLS(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.RequireTLS = true
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = packetConn.Close() }()
+
+	transport := newDatagramTransport(room.server, packetConn)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000}
+	if err := transport.receive(addr, []byte{datagramReliable, 0, 0}); !errors.Is(err, ErrTLSRequired) {
+		t.Errorf("datagram handshake accepted %v", err)
+	}
+	if _, ok := transport.conns.Load(addr.String()); ok {
+		t.Error("connection is made without tls")
+	}
+}
+
+func TestPendingReliableConns(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.MaxPendingHandshakes = 1
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = packetConn.Close() }()
+
+	transport := newDatagramTransport(room.server, packetConn)
+	open := []byte{datagramReliable, 0, 0}
+	for i, want := range []bool{true, false} {
+		addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10000 + i}
+		err := transport.receive(addr, open)
+		if _, ok := transport.conns.Load(addr.String()); ok != want {
+			t.Errorf("invalid connection of %v get: %v, want: %v, %v", addr, ok, want, err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package iguagile
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// resumeTokenSize is the size of the token to resume the session.
+const resumeTokenSize = 16
+
+// ErrSessionNotFound is when the session to resume does not exist or expired.
+var ErrSessionNotFound = errors.New("session not found")
+
+// openSession issues a resume token to the client.
+func (r *Room) openSession(client *Client) {
+	token := uuid.New()
+	client.resumeToken = token[:]
+	r.sessions.Store(string(client.resumeToken), client)
+}
+
+// closeSession discards the resume token of the client.
+func (r *Room) closeSession(client *Client) {
+	if client.resumeToken != nil {
+		r.sessions.Delete(string(client.resumeToken))
+	}
+}
+
+// disconnect suspends the session of the client for ResumeTimeout, or closes
+// the connection if resuming is disabled, the room is closed or the server
+// shuts down. The messages failed to be sent are kept for the client.
+func (r *Room) disconnect(client *Client, done chan struct{}, unsent ...[]byte) {
+	if r.server.ResumeTimeout > 0 && !r.isClosed() && !r.server.isDraining() {
+		if client.suspend(done, unsent) {
+			// Shutdown may have missed the session suspended meanwhile.
+			if r.server.isDraining() {
+				r.CloseConnection(client, DisconnectLeft)
+			}
+			return
+		}
+	}
+
+	if client.isCurrentSession(done) {
+		r.CloseConnection(client, DisconnectLeft)
+	}
+}
+
+// closeSuspendedSessions unregisters the clients waiting to resume the
+// session.
+func (r *Room) closeSuspendedSessions(reason DisconnectReason) {
+	for _, client := range r.clientManager.Clients() {
+		if client.isSuspended() {
+			r.CloseConnection(client, reason)
+		}
+	}
+}
+
+func (r *Room) isClosed() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.closed
+}
+
+// resume rebinds the client having the token to the connection.
+func (r *Room) resume(conn io.ReadWriteCloser, options *handshakeOptions) error {
+	c, ok := r.sessions.Load(string(options.resumeToken))
+	if !ok || r.server.ResumeTimeout <= 0 {
+		return newHandshakeError(HandshakeInvalidResumeToken, ErrSessionNotFound)
+	}
+
+	client := c.(*Client)
+	client.deltaTransforms.Store(options.deltaTransforms)
+	client.fragmentation.Store(options.fragmentation)
+	response := handshakeSucceeded(client, r.GetHost(), options.compression)
+	if err := client.resume(conn, response, options.compression); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return newHandshakeError(HandshakeInvalidResumeToken, err)
+		}
+		return err
+	}
+
+	if conn, ok := conn.(*reliableConn); ok {
+		conn.transport.bindClient(client, conn.addr)
+	}
+
+	// The room has no host if every client was suspended when the host left.
+	if r.claimHost(client) {
+		r.takeOverGameObjects(client)
+		return r.announceHost(client)
+	}
+
+	return nil
+}
+
+// takeOverGameObjects gives the GameObjects left without owner to the resumed
+// client and notifies all clients, which already have the room state.
+func (r *Room) takeOverGameObjects(client *Client) {
+	var batch sendBatch
+	defer batch.wait()
+	r.gameObjectManager.Lock()
+	defer r.gameObjectManager.Unlock()
+
+	for _, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		if gameObject.owner == nil {
+			r.transferGameObject(&batch, gameObject, client, client)
+		}
+	}
+}
+
+// isCurrentSession reports whether the session of done is not ended yet.
+func (c *Client) isCurrentSession(done chan struct{}) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.done == done && !c.suspended && !c.closed
+}
+
+func (c *Client) isSuspended() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.suspended && !c.closed
+}
+
+func (c *Client) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// suspend ends the session of done and keeps the client for ResumeTimeout.
+// It returns false if the session is already ended.
+func (c *Client) suspend(done chan struct{}, unsent [][]byte) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.done != done || c.suspended || c.closed {
+		return false
+	}
+
+	c.suspendLocked()
+	c.missed = append(append(unsent, c.takeQueueLocked()...), c.missed...)
+	return true
+}
+
+// suspendLocked ends the current session. The caller must hold the lock.
+func (c *Client) suspendLocked() {
+	c.suspended = true
+	close(c.done)
+	_ = c.conn.Close()
+	c.resumeTimer = time.AfterFunc(c.room.server.ResumeTimeout, func() {
+		c.room.CloseConnection(c, DisconnectTimeout)
+	})
+}
+
+// addMissed keeps the message for the disconnected client. The session is
+// discarded if the client misses more than MaxMissedMessages messages.
+// The caller must hold the lock.
+func (c *Client) addMissed(message []byte) {
+	if len(c.missed) >= c.room.server.MaxMissedMessages {
+		if c.resumeTimer.Stop() {
+			c.resumeTimer.Reset(0)
+		}
+		return
+	}
+
+	c.missed = append(c.missed, message)
+}
+
+// markClosed ends the session for good and reports whether the client was
+// not closed yet.
+func (c *Client) markClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return false
+	}
+
+	c.closed = true
+	if c.resumeTimer != nil {
+		c.resumeTimer.Stop()
+	}
+	if !c.suspended {
+		close(c.done)
+	}
+	c.missed = nil
+	c.queue = nil
+	return true
+}
+
+// resume starts a new session on the connection with the compression. The
+// response is written first, followed by the messages missed while the client
+// was disconnected. The old connection is dropped if it is still alive.
+func (c *Client) resume(conn io.ReadWriteCloser, response []byte, compressionID CompressionID) error {
+	c.mutex.Lock()
+	if !c.suspended && !c.closed {
+		c.suspendLocked()
+		c.missed = append(c.takeQueueLocked(), c.missed...)
+	}
+
+	if c.closed || !c.resumeTimer.Stop() {
+		c.mutex.Unlock()
+		return ErrSessionNotFound
+	}
+
+	c.conn = conn
+	c.compressionID = compressionID
+	c.mutex.Unlock()
+
+	compression := c.room.server.compression(compressionID)
+	var missed [][]byte
+	fragmentation := c.fragmentation.Load()
+	err := writeMessage(conn, response, false)
+	for err == nil {
+		c.mutex.Lock()
+		if c.closed {
+			c.mutex.Unlock()
+			return ErrConnectionClosed
+		}
+
+		missed = c.missed
+		c.missed = nil
+		if len(missed) == 0 {
+			c.suspended = false
+			c.done = make(chan struct{})
+			done := c.done
+			c.mutex.Unlock()
+
+			go c.writeStart(conn, done, compression)
+			go c.readStart(conn, done, compression)
+			go c.heartbeatStart(done)
+			return nil
+		}
+		c.mutex.Unlock()
+
+		for len(missed) > 0 && err == nil {
+			data := c.encodeMessage(compression, missed[0])
+			if !fitsFrame(data, fragmentation) {
+				c.room.log.Printf("%v for client %v: %v bytes\n", ErrMessageTooLarge, c.id, len(data))
+			} else if err = writeMessage(conn, data, fragmentation); err != nil {
+				break
+			}
+			missed = missed[1:]
+		}
+	}
+
+	c.mutex.Lock()
+	if !c.closed {
+		c.missed = append(missed, c.missed...)
+		c.resumeTimer.Reset(c.room.server.ResumeTimeout)
+	}
+	c.mutex.Unlock()
+	return err
+}
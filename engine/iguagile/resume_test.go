@@ -0,0 +1,152 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func resumeTestSession(room *Room, resumeToken []byte) (net.Conn, []byte, error) {
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		if err := room.server.Serve(serverConn); err != nil {
+			_ = serverConn.Close()
+		}
+	}()
+
+	request := make([]byte, 4, 6+resumeTokenSize)
+	binary.LittleEndian.PutUint32(request, roomID)
+	request = append(request, handshakeOptionResumeToken, resumeTokenSize)
+	for _, data := range [][]byte{append(request, resumeToken...), []byte(appName), []byte(appVersion)} {
+		if err := send(clientConn, data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(clientConn, buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return clientConn, buf[:n], nil
+}
+
+func waitSuspended(t *testing.T, room *Room, clientID int) {
+	t.Helper()
+	client, err := room.clientManager.Get(clientID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for !client.isSuspended() {
+		if time.Now().After(deadline) {
+			t.Fatal("client is not suspended")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestResumeSession(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.ResumeTimeout = time.Second
+	room.server.MaxMissedMessages = 16
+
+	first, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = first.conn.Close()
+	waitSuspended(t, room, first.id)
+
+	missed := NewOutboundMessage(encodeClientID(second.id), RPC, []byte{1, 2, 3})
+	buf := append([]byte{}, missed...)
+	room.SendToAllClients(second.id, buf)
+	// The sender may reuse the buffer after sending.
+	buf[len(buf)-1] = 0
+
+	conn, response, err := resumeTestSession(room, first.resumeToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response[0] != byte(HandshakeSucceeded) ||
+		!bytes.Equal(response[1:3], encodeClientID(first.id)) ||
+		!bytes.Equal(response[5:5+resumeTokenSize], first.resumeToken) {
+		t.Fatalf("invalid handshake response %v", response)
+	}
+
+	buf = make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], missed) {
+		t.Errorf("invalid missed message get: %v, want: %v", buf[:n], missed)
+	}
+
+	if count := room.clientManager.Count(); count != 2 {
+		t.Errorf("invalid client count %v", count)
+	}
+
+	go func() {
+		for {
+			if _, err := receive(conn, make([]byte, maxMessageSize)); err != nil {
+				return
+			}
+		}
+	}()
+
+	message := NewOutboundMessage(encodeClientID(first.id), RPC, []byte{4, 5, 6})
+	if err := send(conn, message); err != nil {
+		t.Fatal(err)
+	}
+	second.expect(t, second.id, RPC, []byte{1, 2, 3})
+	second.expect(t, first.id, RPC, []byte{4, 5, 6})
+}
+
+func TestResumeTimeout(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.ResumeTimeout = time.Millisecond * 100
+	room.server.MaxMissedMessages = 16
+	room.server.EmptyRoomTimeout = -1
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = client.conn.Close()
+	waitSuspended(t, room, client.id)
+
+	deadline := time.Now().Add(time.Second)
+	for room.clientManager.Exist(client.id) {
+		if time.Now().After(deadline) {
+			t.Fatal("suspended client is not unregistered after the timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, response, err := resumeTestSession(room, client.resumeToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response[0] != byte(HandshakeInvalidResumeToken) {
+		t.Errorf("expired session is resumed %v", response)
+	}
+}
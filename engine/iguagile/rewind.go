@@ -0,0 +1,55 @@
+package iguagile
+
+import "time"
+
+// ObjectState is the state of a GameObject at a point in time.
+type ObjectState struct {
+	ObjectID  int
+	Time      time.Time
+	Transform []byte
+
+	// Position is nil if the owner has not reported it by then.
+	Position *Vector3
+}
+
+// stateAt returns the latest transform recorded at or before the time.
+func (o *GameObject) stateAt(t time.Time) (transformState, bool) {
+	for i := 0; i < transformHistorySize; i++ {
+		state, ok := o.historyState(o.transformSequence - uint16(i))
+		if !ok {
+			break
+		}
+
+		if !state.at.After(t) {
+			return state, true
+		}
+	}
+
+	return transformState{}, false
+}
+
+// Rewind returns the states of the GameObjects at the time, for example the
+// time a client saw the room when it fired, that is about now minus half of
+// its RTT. GameObjects without a transform recorded by then, or recorded too
+// long before to be kept, are left out.
+func (r *Room) Rewind(t time.Time) []ObjectState {
+	r.gameObjectManager.Lock()
+	defer r.gameObjectManager.Unlock()
+
+	states := make([]ObjectState, 0)
+	for id, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		state, ok := gameObject.stateAt(t)
+		if !ok {
+			continue
+		}
+
+		states = append(states, ObjectState{
+			ObjectID:  id,
+			Time:      state.at,
+			Transform: state.data,
+			Position:  state.position,
+		})
+	}
+
+	return states
+}
@@ -0,0 +1,47 @@
+package iguagile
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestRewind(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	gameObject := &GameObject{id: 1}
+	if err := room.gameObjectManager.Add(gameObject); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < transformHistorySize+10; i++ {
+		gameObject.updateTransform([]byte{byte(i)}, start.Add(time.Duration(i)*time.Millisecond))
+	}
+
+	tests := []struct {
+		at        time.Duration
+		transform []byte
+	}{
+		{at: 20 * time.Millisecond, transform: []byte{20}},
+		{at: 20*time.Millisecond + time.Microsecond, transform: []byte{20}},
+		{at: time.Second, transform: []byte{transformHistorySize + 9}},
+		{at: 5 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		states := room.Rewind(start.Add(tt.at))
+		if tt.transform == nil {
+			if len(states) != 0 {
+				t.Errorf("state out of history %v", states)
+			}
+			continue
+		}
+
+		if len(states) != 1 || states[0].ObjectID != 1 || !bytes.Equal(states[0].Transform, tt.transform) {
+			t.Errorf("invalid state at %v get: %v, want: %v", tt.at, states, tt.transform)
+		}
+	}
+}
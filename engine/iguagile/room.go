@@ -1,10 +1,13 @@
 package iguagile
 
 import (
+	"errors"
 	"io"
 	"log"
 	"math"
 	"os"
+	"sync"
+	"time"
 
 	pb "github.com/iguagile/iguagile-room-proto/room"
 )
@@ -12,17 +15,29 @@ import (
 // Room maintains the set of active clients and broadcasts messages to the
 // clients.
 type Room struct {
-	clientManager    *ClientManager
-	rpcBufferManager *RPCBufferManager
-	generator        *IDGenerator
-	log              *log.Logger
-	host             *Client
-	config           *RoomConfig
-	creatorConnected bool
-	roomProto        *pb.Room
-	store            Store
-	server           *RoomServer
-	service          RoomService
+	clientManager     *ClientManager
+	gameObjectManager *GameObjectManager
+	rpcBufferManager  *RPCBufferManager
+	groupManager      *GroupManager
+	generator         *IDGenerator
+	log               *log.Logger
+	host              *Client
+	hostMutex         *sync.Mutex
+	config            *RoomConfig
+	creatorConnected  bool
+	roomProto         *pb.Room
+	mutex             *sync.Mutex
+	closed            bool
+	emptyTimer        *time.Timer
+	creatorTimer      *time.Timer
+	sessions          *sync.Map
+	store             Store
+	server            *RoomServer
+	service           RoomService
+	ticker            *tickLoop
+	closing           chan struct{}
+	datagrams         chan inboundDatagram
+	datagramOnce      *sync.Once
 }
 
 // RoomConfig is room config.
@@ -34,6 +49,10 @@ type RoomConfig struct {
 	MaxUser         int
 	Info            map[string]string
 	Token           []byte
+
+	// MaxMessageSize is the maximum size of a message reassembled from
+	// fragments. Fragmented messages are rejected if zero.
+	MaxMessageSize int
 }
 
 func newRoom(server *RoomServer, config *RoomConfig) (*Room, error) {
@@ -43,72 +62,343 @@ func newRoom(server *RoomServer, config *RoomConfig) (*Room, error) {
 	}
 
 	return &Room{
-		clientManager:    NewClientManager(),
-		rpcBufferManager: NewRPCBufferManager(),
-		generator:        gen,
-		log:              log.New(os.Stdout, "iguagile-engine ", log.Lshortfile),
-		config:           config,
-		store:            server.store,
-		roomProto:        &pb.Room{},
-		server:           server,
+		clientManager:     NewClientManager(),
+		gameObjectManager: NewGameObjectManager(),
+		rpcBufferManager:  NewRPCBufferManager(),
+		groupManager:      NewGroupManager(),
+		generator:         gen,
+		hostMutex:         &sync.Mutex{},
+		mutex:             &sync.Mutex{},
+		closing:           make(chan struct{}),
+		datagrams:         make(chan inboundDatagram, datagramQueueSize),
+		datagramOnce:      &sync.Once{},
+		log:               log.New(os.Stdout, "iguagile-engine ", log.Lshortfile),
+		config:            config,
+		store:             server.store,
+		roomProto:         &pb.Room{},
+		sessions:          &sync.Map{},
+		server:            server,
 	}, nil
 }
 
-func (r *Room) serve(conn io.ReadWriteCloser) error {
+func (r *Room) serve(conn io.ReadWriteCloser, options *handshakeOptions) error {
 	client, err := NewClient(r, conn)
 	if err != nil {
-		return err
+		return newHandshakeError(HandshakeInternalError, err)
 	}
+	client.compressionID = options.compression
+	client.deltaTransforms.Store(options.deltaTransforms)
+	client.fragmentation.Store(options.fragmentation)
 
-	r.roomProto.ConnectedUser = int32(r.clientManager.count + 1)
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		if err := r.generator.Free(client.id); err != nil {
+			r.log.Println(err)
+		}
+		return newHandshakeError(HandshakeRoomNotFound, ErrRoomClosed)
+	}
+
+	if r.emptyTimer != nil {
+		r.emptyTimer.Stop()
+		r.emptyTimer = nil
+	}
+
+	r.roomProto.ConnectedUser = int32(r.clientManager.Count() + 1)
 	if err := r.store.RegisterRoom(r.roomProto); err != nil {
 		r.log.Println(err)
 	}
-	return r.register(client)
+	r.mutex.Unlock()
+
+	if err := r.register(client); err != nil {
+		switch {
+		case errors.Is(err, ErrRoomFull):
+			if err := r.generator.Free(client.id); err != nil {
+				r.log.Println(err)
+			}
+			return newHandshakeError(HandshakeRoomFull, err)
+		case errors.Is(err, ErrRoomClosed):
+			if err := r.generator.Free(client.id); err != nil {
+				r.log.Println(err)
+			}
+			return newHandshakeError(HandshakeRoomNotFound, err)
+		}
+		return err
+	}
+
+	return nil
 }
 
 const (
 	// Maximum message size allowed from peer.
 	maxMessageSize = math.MaxUint16
+
+	// Maximum size of a frame.
+	maxFrameSize = math.MaxUint16
 )
 
 // register requests from the clients.
 func (r *Room) register(client *Client) error {
-	if err := r.clientManager.Add(client); err != nil {
+	conn, done := client.conn, client.done
+	compression := r.server.compression(client.compressionID)
+	if err := r.clientManager.AddWithLimit(client, r.config.MaxUser); err != nil {
 		return err
 	}
 
-	go client.writeStart()
-	if r.clientManager.Count() == 1 {
-		r.host = client
+	// The room may be closed as empty before the client is added, and then
+	// the client would be left in the closed room.
+	if r.isClosed() {
+		r.clientManager.Remove(client.id)
+		return ErrRoomClosed
 	}
 
-	go client.readStart()
+	if r.claimHost(client) {
+		r.adoptGameObjects(client)
+	}
+
+	// The response is written before the writer starts so that the client
+	// receives it first and uncompressed.
+	r.openSession(client)
+	if err := writeMessage(conn, handshakeSucceeded(client, r.GetHost(), client.compressionID), false); err != nil {
+		r.log.Println(err)
+	}
 
-	return r.service.OnRegisterClient(client.id)
+	go client.writeStart(conn, done, compression)
+
+	if conn, ok := conn.(*reliableConn); ok {
+		conn.transport.bindClient(client, conn.addr)
+	} else if datagram := r.server.datagram.Load(); datagram != nil {
+		client.Send(NewOutboundMessage(client.idByte, BindDatagram, datagram.open(client)))
+	}
+
+	go client.readStart(conn, done, compression)
+	go client.heartbeatStart(done)
+
+	return r.dispatch(func() error {
+		return r.service.OnRegisterClient(client.id)
+	})
 }
 
 // unregister requests from clients.
-func (r *Room) unregister(client *Client) error {
+func (r *Room) unregister(client *Client, reason DisconnectReason) error {
 	if err := r.generator.Free(client.GetID()); err != nil {
 		r.log.Println(err)
 	}
 
 	r.clientManager.Remove(client.GetID())
-	if client == r.host {
-		c, err := r.clientManager.First()
+	r.groupManager.RemoveClient(client.GetID())
+	r.closeSession(client)
+	if datagram, _ := client.datagramSession(); datagram != nil {
+		datagram.close(client)
+	}
+
+	if client == r.GetHost() {
+		if err := r.migrateHost(); err != nil {
+			r.log.Println(err)
+		}
+	}
+
+	r.releaseGameObjects(client)
+	r.SendToAllClients(client.id, NewOutboundMessage(client.idByte, ExitConnect, []byte{byte(reason)}))
+
+	err := r.dispatch(func() error {
+		return r.service.OnUnregisterClient(client.id, reason)
+	})
+	if r.clientManager.Count() == 0 {
+		r.scheduleEmptyClose()
+	}
+
+	return err
+}
+
+// ErrRoomClosed is when the room is already closed.
+var ErrRoomClosed = errors.New("room closed")
+
+// scheduleEmptyClose closes the empty room after EmptyRoomTimeout.
+func (r *Room) scheduleEmptyClose() {
+	timeout := r.server.EmptyRoomTimeout
+	if timeout < 0 {
+		return
+	}
+
+	if timeout == 0 {
+		r.closeIfEmpty()
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed || r.emptyTimer != nil {
+		return
+	}
+
+	r.emptyTimer = time.AfterFunc(timeout, r.closeIfEmpty)
+}
+
+// closeIfEmpty closes the room if no client is registered. The clients
+// registered check that the room is not closed after they are added.
+func (r *Room) closeIfEmpty() {
+	r.mutex.Lock()
+	r.emptyTimer = nil
+	closed := r.clientManager.Count() == 0 && r.markClosed()
+	r.mutex.Unlock()
+
+	if !closed {
+		return
+	}
+
+	if err := r.destroy(); err != nil {
+		r.log.Println(err)
+	}
+}
+
+// closeIfCreatorAbsent closes the room if the creator has not connected.
+func (r *Room) closeIfCreatorAbsent() {
+	r.mutex.Lock()
+	creatorConnected := r.creatorConnected
+	r.mutex.Unlock()
+
+	if creatorConnected {
+		return
+	}
+
+	if err := r.Close(); err != nil {
+		r.log.Println(err)
+	}
+}
+
+// MaxMessageSize returns the maximum size of a message reassembled from
+// fragments.
+func (r *Room) MaxMessageSize() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.config.MaxMessageSize
+}
+
+// SetMaxMessageSize changes the maximum size of a message reassembled from
+// fragments in the room.
+func (r *Room) SetMaxMessageSize(size int) {
+	r.mutex.Lock()
+	r.config.MaxMessageSize = size
+	r.mutex.Unlock()
+}
+
+// GetHost returns the host of the room.
+func (r *Room) GetHost() *Client {
+	r.hostMutex.Lock()
+	defer r.hostMutex.Unlock()
+	return r.host
+}
+
+func (r *Room) setHost(client *Client) {
+	r.hostMutex.Lock()
+	r.host = client
+	r.hostMutex.Unlock()
+}
+
+// claimHost makes the client the host if there is no host.
+func (r *Room) claimHost(client *Client) bool {
+	r.hostMutex.Lock()
+	defer r.hostMutex.Unlock()
+	if r.host != nil {
+		return false
+	}
+
+	r.host = client
+	return true
+}
+
+// migrateHost elects a new host and notifies all clients and the service.
+func (r *Room) migrateHost() error {
+	host, err := r.clientManager.Elect(r.server.HostElection)
+	if err != nil {
+		r.setHost(nil)
+		return nil
+	}
+
+	r.setHost(host)
+	return r.announceHost(host)
+}
+
+// announceHost notifies all clients and the service of the new host.
+func (r *Room) announceHost(host *Client) error {
+	r.SendToAllClients(host.id, NewOutboundMessage(host.idByte, MigrateHost, nil))
+	return r.dispatch(func() error {
+		return r.service.OnChangeHost(host.id)
+	})
+}
+
+// releaseGameObjects destroys the GameObjects that live with the client and
+// hands the others over to the host.
+func (r *Room) releaseGameObjects(client *Client) {
+	var batch sendBatch
+	defer batch.wait()
+	r.gameObjectManager.Lock()
+	defer r.gameObjectManager.Unlock()
+
+	r.gameObjectManager.unsync(client)
+	for id, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		gameObject.removeRequester(client.id)
+		delete(gameObject.acks, client.id)
+		if gameObject.owner != client {
+			continue
+		}
+
+		switch gameObject.lifetime {
+		case ownerExist:
+			r.gameObjectManager.Remove(id)
+			r.ClearRPCBuffer(id)
+			batch.sendAll(r.gameObjectManager.syncedClients(), NewOutboundMessage(client.idByte, Destroy, encodeObjectID(id)), true)
+		case roomExist:
+			host := r.GetHost()
+			if host == nil {
+				gameObject.changeOwner(nil)
+				continue
+			}
+			r.transferGameObject(&batch, gameObject, client, host)
+		}
+	}
+}
+
+// adoptGameObjects gives the GameObjects left without owner to the client.
+// The client learns the owner when the room state is sent to it.
+func (r *Room) adoptGameObjects(client *Client) {
+	r.gameObjectManager.Lock()
+	defer r.gameObjectManager.Unlock()
+
+	for _, gameObject := range r.gameObjectManager.GetAllGameObjects() {
+		if gameObject.owner == nil {
+			gameObject.changeOwner(client)
+		}
+	}
+}
+
+// transferGameObject changes the owner of the GameObject, notifies all clients
+// and denies the pending ownership requests with the batch.
+// The caller must hold the lock of the GameObjectManager.
+func (r *Room) transferGameObject(batch *sendBatch, gameObject *GameObject, from, to *Client) {
+	objectID := encodeObjectID(gameObject.id)
+	for _, requesterID := range gameObject.changeOwner(to) {
+		requester, err := r.clientManager.Get(requesterID)
 		if err != nil {
-			return err
+			r.log.Println(err)
+			continue
 		}
-		r.host = c
+		batch.send(requester, NewOutboundMessage(from.idByte, DenyOwnership, objectID), true)
 	}
 
-	return r.service.OnUnregisterClient(client.id)
+	payload := append(objectID, to.idByte...)
+	batch.sendAll(r.gameObjectManager.syncedClients(), NewOutboundMessage(from.idByte, TransferOwnership, payload), true)
 }
 
 // SendToHost sends outbound message to the host.
 func (r *Room) SendToHost(senderID int, message []byte) {
-	r.host.Send(message)
+	host := r.GetHost()
+	if host == nil {
+		return
+	}
+
+	host.Send(message)
 }
 
 // SendToClient sends outbound message to the client.
@@ -124,27 +414,75 @@ func (r *Room) SendToClient(targetID, senderID int, message []byte) {
 
 // SendToAllClients sends outbound message to all registered clients.
 func (r *Room) SendToAllClients(senderID int, message []byte) {
-	r.clientManager.Lock()
-	defer r.clientManager.Unlock()
-	for _, client := range r.clientManager.GetAllClients() {
-		client.Send(message)
+	message = append([]byte{}, message...)
+	for _, client := range r.clientManager.Clients() {
+		client.send(message, true)
 	}
 }
 
 // SendToOtherClients sends outbound message to other registered clients.
 func (r *Room) SendToOtherClients(senderID int, message []byte) {
-	r.clientManager.Lock()
-	defer r.clientManager.Unlock()
-	for id, client := range r.clientManager.GetAllClients() {
-		if id != senderID {
-			client.Send(message)
+	message = append([]byte{}, message...)
+	for _, client := range r.clientManager.Clients() {
+		if client.id != senderID {
+			client.send(message, true)
 		}
 	}
 }
 
+// SendQueueMetrics returns the statistics of the send queues by client id.
+func (r *Room) SendQueueMetrics() map[int]SendQueueMetrics {
+	metrics := make(map[int]SendQueueMetrics)
+	for _, client := range r.clientManager.Clients() {
+		metrics[client.id] = client.SendQueueMetrics()
+	}
+	return metrics
+}
+
+// ClearRPCBuffer removes the buffered rpc messages sent to the GameObject.
+func (r *Room) ClearRPCBuffer(objectID int) {
+	r.rpcBufferManager.RemoveObject(objectID)
+}
+
+// SendUnreliableToAllClients sends outbound message to all registered clients
+// over the datagram transport where available.
+func (r *Room) SendUnreliableToAllClients(senderID int, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.clientManager.Clients() {
+		client.send(message, false)
+	}
+}
+
+// SendUnreliableToOtherClients sends outbound message to other registered
+// clients over the datagram transport where available.
+func (r *Room) SendUnreliableToOtherClients(senderID int, message []byte) {
+	message = append([]byte{}, message...)
+	for _, client := range r.clientManager.Clients() {
+		if client.id != senderID {
+			client.send(message, false)
+		}
+	}
+}
+
+// Kick closes the connection of the client with DisconnectKicked.
+func (r *Room) Kick(clientID int) error {
+	client, err := r.clientManager.Get(clientID)
+	if err != nil {
+		return err
+	}
+
+	r.CloseConnection(client, DisconnectKicked)
+	return nil
+}
+
 // CloseConnection closes the connection and unregisters the client.
-func (r *Room) CloseConnection(client *Client) {
-	if err := r.unregister(client); err != nil {
+// The session of the client can not be resumed after that.
+func (r *Room) CloseConnection(client *Client, reason DisconnectReason) {
+	if !client.markClosed() {
+		return
+	}
+
+	if err := r.unregister(client, reason); err != nil {
 		r.log.Println(err)
 	}
 	if err := client.Close(); err != nil && err.Error() != "use of closed network connection" {
@@ -152,16 +490,63 @@ func (r *Room) CloseConnection(client *Client) {
 	}
 }
 
-// Close closes all client connections.
+// Close closes all client connections and destroys the room.
 func (r *Room) Close() error {
-	r.clientManager.Lock()
-	defer r.clientManager.Unlock()
-	for _, client := range r.clientManager.GetAllClients() {
-		if err := client.Close(); err != nil {
-			r.log.Println(err)
-		}
+	r.mutex.Lock()
+	closed := r.markClosed()
+	r.mutex.Unlock()
+
+	if !closed {
+		return nil
+	}
+
+	return r.destroy()
+}
+
+// markClosed marks the room closed and reports whether it was open.
+// The caller must hold the mutex of the room.
+func (r *Room) markClosed() bool {
+	if r.closed {
+		return false
+	}
+
+	r.closed = true
+	close(r.closing)
+	if r.emptyTimer != nil {
+		r.emptyTimer.Stop()
+	}
+	if r.creatorTimer != nil {
+		r.creatorTimer.Stop()
+	}
+	return true
+}
+
+// destroy closes all client connections and destroys the room marked closed.
+func (r *Room) destroy() error {
+	reason := DisconnectRoomClosed
+	if r.server.isDraining() {
+		reason = DisconnectServerShutdown
+	}
+	for _, client := range r.clientManager.Clients() {
+		r.CloseConnection(client, reason)
 	}
 
 	r.server.rooms.Delete(r.config.RoomID)
+	if err := r.server.idGenerator.Free(r.config.RoomID & 0xffff); err != nil {
+		r.log.Println(err)
+	}
+
+	r.mutex.Lock()
+	if err := r.store.UnregisterRoom(r.roomProto); err != nil {
+		r.log.Println(err)
+	}
+	r.mutex.Unlock()
+
+	// The tick loop destroys the service after the pending events.
+	if r.ticker != nil {
+		r.stopTicks()
+		return nil
+	}
+
 	return r.service.Destroy()
 }
@@ -0,0 +1,119 @@
+package iguagile
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pb "github.com/iguagile/iguagile-room-proto/room"
+)
+
+func waitRoomClosed(room *Room, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if _, ok := room.server.rooms.Load(room.config.RoomID); !ok {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return false
+}
+
+func TestEmptyRoomTimeout(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.EmptyRoomTimeout = time.Millisecond * 100
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.conn.Close()
+
+	for scheduled := false; !scheduled; {
+		room.mutex.Lock()
+		scheduled = room.emptyTimer != nil
+		room.mutex.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+
+	client, err = joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if waitRoomClosed(room, time.Millisecond*200) {
+		t.Fatal("room with client is closed")
+	}
+
+	_ = client.conn.Close()
+	if !waitRoomClosed(room, time.Second) {
+		t.Error("empty room is not closed after the timeout")
+	}
+}
+
+func TestCloseEmptyRoom(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = client.conn.Close()
+
+	if !waitRoomClosed(room, time.Second) {
+		t.Error("empty room is not closed")
+	}
+}
+
+func TestJoinRoomClosedAsEmpty(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client handshaking when the empty room is closed is not left in
+	// the closed room.
+	room.closeIfEmpty()
+	if _, err := joinTestRoom(room); !errors.Is(err, ErrRoomClosed) {
+		t.Errorf("invalid error get: %v, want: %v", err, ErrRoomClosed)
+	}
+	if count := room.clientManager.Count(); count != 0 {
+		t.Errorf("invalid client count %v", count)
+	}
+}
+
+func TestCreatorConnectTimeout(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := room.server
+	server.factory = RelayServiceFactory{}
+	server.serverProto = &pb.Server{Token: []byte{1}}
+	server.CreatorConnectTimeout = time.Millisecond * 50
+
+	response, err := server.CreateRoom(context.Background(), &pb.CreateRoomRequest{
+		ServerToken: []byte{1},
+		MaxUser:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, ok := server.rooms.Load(int(response.Room.RoomId))
+	if !ok {
+		t.Fatal("room is not created")
+	}
+
+	if !waitRoomClosed(r.(*Room), time.Second) {
+		t.Error("room without creator is not closed")
+	}
+}
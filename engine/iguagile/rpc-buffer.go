@@ -4,50 +4,88 @@ import (
 	"sync"
 )
 
-// RPCBufferManager manages buffered rpc messages.
+// rpcBuffer is a buffered rpc message.
+type rpcBuffer struct {
+	objectID int
+	sender   *Client
+	message  []byte
+}
+
+// RPCBufferManager manages buffered rpc messages in the order they were sent.
 type RPCBufferManager struct {
-	buffer map[*[]byte]*Client
+	buffer []*rpcBuffer
 	sync.Mutex
 }
 
 // NewRPCBufferManager is RPCBufferManger constructed.
 func NewRPCBufferManager() *RPCBufferManager {
 	return &RPCBufferManager{
-		buffer: make(map[*[]byte]*Client),
+		buffer: make([]*rpcBuffer, 0),
 		Mutex:  sync.Mutex{},
 	}
 }
 
-// Add new rpc message.
-func (m *RPCBufferManager) Add(message []byte, sender *Client) {
+// Add new rpc message sent to the GameObject.
+func (m *RPCBufferManager) Add(objectID int, message []byte, sender *Client) {
 	m.Lock()
-	m.buffer[&message] = sender
+	m.buffer = append(m.buffer, &rpcBuffer{
+		objectID: objectID,
+		sender:   sender,
+		message:  message,
+	})
 	m.Unlock()
 }
 
-// Remove rpc messages.
+// Remove rpc messages sent by the client.
 func (m *RPCBufferManager) Remove(client *Client) {
+	m.remove(func(buffer *rpcBuffer) bool {
+		return buffer.sender == client
+	})
+}
+
+// RemoveObject removes rpc messages sent to the GameObject.
+func (m *RPCBufferManager) RemoveObject(objectID int) {
+	m.remove(func(buffer *rpcBuffer) bool {
+		return buffer.objectID == objectID
+	})
+}
+
+func (m *RPCBufferManager) remove(match func(*rpcBuffer) bool) {
 	m.Lock()
-	for buffer, sender := range m.buffer {
-		if sender == client {
-			delete(m.buffer, buffer)
+	buffer := m.buffer[:0]
+	for _, b := range m.buffer {
+		if !match(b) {
+			buffer = append(buffer, b)
 		}
 	}
+	for i := len(buffer); i < len(m.buffer); i++ {
+		m.buffer[i] = nil
+	}
+	m.buffer = buffer
 	m.Unlock()
 }
 
 // Clear all rpc messages.
 func (m *RPCBufferManager) Clear() {
 	m.Lock()
-	m.buffer = make(map[*[]byte]*Client)
+	m.buffer = make([]*rpcBuffer, 0)
 	m.Unlock()
 }
 
-// SendRPCBuffer sends all buffered rpc messages.
+// SendRPCBuffer sends all buffered rpc messages in the order they were sent.
 func (m *RPCBufferManager) SendRPCBuffer(client *Client) {
+	var batch sendBatch
+	m.sendRPCBuffer(&batch, client)
+	batch.wait()
+}
+
+// sendRPCBuffer queues the buffered rpc messages to the client with the batch.
+func (m *RPCBufferManager) sendRPCBuffer(batch *sendBatch, client *Client) {
 	m.Lock()
-	for buffer := range m.buffer {
-		client.Send(*buffer)
+	defer m.Unlock()
+	for _, buffer := range m.buffer {
+		if buffer.sender != client {
+			batch.send(client, buffer.message, true)
+		}
 	}
-	m.Unlock()
 }
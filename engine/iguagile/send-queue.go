@@ -0,0 +1,274 @@
+package iguagile
+
+import (
+	"errors"
+	"time"
+)
+
+// OverflowPolicy decides what happens when the send queue of a client is full.
+type OverflowPolicy int
+
+// Overflow policies
+const (
+	// DropOldestUnreliable drops the oldest unreliable message in the queue.
+	// An unreliable message is dropped itself if no unreliable message is
+	// queued, and the client is disconnected for a reliable message.
+	DropOldestUnreliable OverflowPolicy = iota
+
+	// DisconnectOnOverflow disconnects the client.
+	DisconnectOnOverflow
+
+	// BlockOnOverflow blocks the sender until the queue has room, and
+	// disconnects the client after SendTimeout. The messages sent while the
+	// room holds the lock of the GameObjects are queued over the limit, and
+	// the sender blocks after releasing the lock.
+	BlockOnOverflow
+)
+
+// ErrSendQueueOverflow is when the client does not read messages fast enough.
+var ErrSendQueueOverflow = errors.New("send queue overflow")
+
+type queuedMessage struct {
+	data     []byte
+	reliable bool
+}
+
+// SendQueueMetrics is the statistics of the send queue of the client.
+type SendQueueMetrics struct {
+	// Depth is the number of messages waiting to be sent.
+	Depth int
+
+	// MaxDepth is the largest depth observed.
+	MaxDepth int
+
+	// Dropped is the number of messages dropped on overflow.
+	Dropped int
+}
+
+// SendQueueMetrics returns the statistics of the send queue.
+func (c *Client) SendQueueMetrics() SendQueueMetrics {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return SendQueueMetrics{
+		Depth:    len(c.queue),
+		MaxDepth: c.maxQueueDepth,
+		Dropped:  c.droppedMessages,
+	}
+}
+
+// enqueue adds the message to the send queue and handles the overflow with
+// the SendQueuePolicy of the server.
+func (c *Client) enqueue(message []byte, reliable bool) {
+	var deadline time.Time
+	for c.offer(message, reliable, false) {
+		if deadline.IsZero() {
+			deadline = time.Now().Add(c.room.server.SendTimeout)
+		}
+		if !c.waitSpace(c.room.server.SendQueueSize-1, deadline) {
+			return
+		}
+	}
+}
+
+// offer adds the message to the send queue unless the queue is full under
+// BlockOnOverflow, and reports whether it is. The message is queued over the
+// limit in that case if force is set.
+func (c *Client) offer(message []byte, reliable, force bool) bool {
+	server := c.room.server
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return false
+	}
+
+	if c.suspended {
+		if reliable {
+			c.addMissed(message)
+		}
+		c.mutex.Unlock()
+		return false
+	}
+
+	if len(c.queue) < server.SendQueueSize {
+		c.pushLocked(message, reliable)
+		c.mutex.Unlock()
+		return false
+	}
+
+	switch server.SendQueuePolicy {
+	case DropOldestUnreliable:
+		c.droppedMessages++
+		if i := c.oldestUnreliable(); i >= 0 {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			c.pushLocked(message, reliable)
+			c.mutex.Unlock()
+			return false
+		}
+		c.mutex.Unlock()
+
+		if reliable {
+			c.closeSlowClient()
+		}
+		return false
+	case BlockOnOverflow:
+		if force {
+			c.pushLocked(message, reliable)
+		}
+		c.mutex.Unlock()
+		return true
+	default:
+		c.mutex.Unlock()
+		c.closeSlowClient()
+		return false
+	}
+}
+
+// waitSpace blocks the sender until the send queue has no more than limit
+// messages, and disconnects the client if it does not happen by the deadline.
+// It reports false if the client is disconnected.
+func (c *Client) waitSpace(limit int, deadline time.Time) bool {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	for {
+		c.mutex.Lock()
+		if c.closed || c.suspended || len(c.queue) <= limit {
+			c.mutex.Unlock()
+			return true
+		}
+		done, space := c.done, c.space
+		c.mutex.Unlock()
+
+		// In the tick mode the writer waits for the end of the tick, which
+		// never comes while the tick goroutine is blocked here.
+		if c.flush != nil {
+			select {
+			case c.flush <- struct{}{}:
+			default:
+			}
+		}
+
+		select {
+		case <-space:
+		case <-done:
+		case <-timer.C:
+			c.closeSlowClient()
+			return false
+		}
+	}
+}
+
+// pushLocked appends the message to the queue. The message is shared by the
+// queues of the recipients, so it must not be modified after that.
+// The caller must hold the lock.
+func (c *Client) pushLocked(message []byte, reliable bool) {
+	c.queue = append(c.queue, queuedMessage{data: message, reliable: reliable})
+	if len(c.queue) > c.maxQueueDepth {
+		c.maxQueueDepth = len(c.queue)
+	}
+
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// sendBatch sends messages without blocking while the caller holds a lock, so
+// that the messages are queued in the order of the changes made under the
+// lock. The clients whose queues are over the limit under BlockOnOverflow are
+// waited for by wait after unlocking.
+type sendBatch struct {
+	full []*Client
+}
+
+// send sends the message the caller does not modify anymore to the client.
+func (b *sendBatch) send(client *Client, message []byte, reliable bool) {
+	if !reliable && client.sendDatagram(message) {
+		return
+	}
+
+	if client.offer(message, reliable, true) {
+		b.full = append(b.full, client)
+	}
+}
+
+// sendAll sends the message to the clients.
+func (b *sendBatch) sendAll(clients []*Client, message []byte, reliable bool) {
+	for _, client := range clients {
+		b.send(client, message, reliable)
+	}
+}
+
+// wait blocks until the queues of the clients come back to the limit. The
+// caller must not hold the lock the messages are sent under.
+func (b *sendBatch) wait() {
+	for _, client := range b.full {
+		server := client.room.server
+		client.waitSpace(server.SendQueueSize, time.Now().Add(server.SendTimeout))
+	}
+}
+
+func (c *Client) oldestUnreliable() int {
+	for i, message := range c.queue {
+		if !message.reliable {
+			return i
+		}
+	}
+	return -1
+}
+
+// dequeue waits for messages in the queue until the session of done ends,
+// and takes all of them.
+func (c *Client) dequeue(done chan struct{}) ([][]byte, bool) {
+	for {
+		if messages := c.takeQueue(done); messages != nil {
+			return messages, true
+		}
+
+		select {
+		case <-c.notify:
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// takeQueue takes all messages in the queue if the session of done is not
+// ended. It returns nil if there are no messages.
+func (c *Client) takeQueue(done chan struct{}) [][]byte {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.done != done || c.suspended || c.closed || len(c.queue) == 0 {
+		return nil
+	}
+
+	messages := make([][]byte, len(c.queue))
+	for i, message := range c.queue {
+		messages[i] = message.data
+	}
+	clear(c.queue)
+	c.queue = c.queue[:0]
+	close(c.space)
+	c.space = make(chan struct{})
+	return messages
+}
+
+// takeQueueLocked empties the queue and returns the reliable messages in it.
+// The caller must hold the lock.
+func (c *Client) takeQueueLocked() [][]byte {
+	messages := make([][]byte, 0, len(c.queue))
+	for _, message := range c.queue {
+		if message.reliable {
+			messages = append(messages, message.data)
+		}
+	}
+	c.queue = nil
+	return messages
+}
+
+// closeSlowClient disconnects the client not reading messages fast enough.
+// The connection is closed asynchronously because the sender may hold locks
+// the unregistration needs.
+func (c *Client) closeSlowClient() {
+	c.room.log.Println(ErrSendQueueOverflow, c.id)
+	go c.room.CloseConnection(c, DisconnectSlowConsumer)
+}
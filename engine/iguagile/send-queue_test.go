@@ -0,0 +1,189 @@
+package iguagile
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newQueueTestClient(t *testing.T, policy OverflowPolicy) *Client {
+	t.Helper()
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.SendQueueSize = 2
+	room.server.SendQueuePolicy = policy
+	room.server.SendTimeout = time.Millisecond * 100
+
+	serverConn, _ := net.Pipe()
+	client, err := NewClient(room, serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+func waitClientClosed(t *testing.T, client *Client) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		client.mutex.Lock()
+		closed := client.closed
+		client.mutex.Unlock()
+		if closed {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow client is not disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDropOldestUnreliable(t *testing.T) {
+	client := newQueueTestClient(t, DropOldestUnreliable)
+
+	client.SendUnreliable([]byte{1})
+	client.Send([]byte{2})
+	client.Send([]byte{3})
+	client.SendUnreliable([]byte{4})
+
+	want := SendQueueMetrics{Depth: 2, MaxDepth: 2, Dropped: 2}
+	if metrics := client.SendQueueMetrics(); metrics != want {
+		t.Errorf("invalid metrics get: %v, want: %v", metrics, want)
+	}
+
+	messages, ok := client.dequeue(client.done)
+	if !ok || !reflect.DeepEqual(messages, [][]byte{{2}, {3}}) {
+		t.Errorf("invalid messages %v", messages)
+	}
+
+	client.Send([]byte{5})
+	client.Send([]byte{6})
+	client.Send([]byte{7})
+	waitClientClosed(t, client)
+}
+
+func TestSendQueueCopy(t *testing.T) {
+	client := newQueueTestClient(t, DropOldestUnreliable)
+
+	buf := []byte{1}
+	client.Send(buf)
+	buf[0] = 2
+	client.Send(buf)
+
+	messages, ok := client.dequeue(client.done)
+	if !ok || !reflect.DeepEqual(messages, [][]byte{{1}, {2}}) {
+		t.Errorf("invalid messages %v", messages)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	client := newQueueTestClient(t, BlockOnOverflow)
+	client.Send([]byte{1})
+	client.Send([]byte{2})
+
+	var batch sendBatch
+	batch.send(client, []byte{3}, true)
+	if metrics := client.SendQueueMetrics(); metrics.Depth != 3 {
+		t.Errorf("message is not queued over the limit %v", metrics)
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		batch.wait()
+		close(waited)
+	}()
+
+	select {
+	case <-waited:
+		t.Fatal("sender does not wait for the full queue")
+	case <-time.After(time.Millisecond * 10):
+	}
+
+	messages, ok := client.dequeue(client.done)
+	if !ok || !reflect.DeepEqual(messages, [][]byte{{1}, {2}, {3}}) {
+		t.Errorf("invalid messages %v", messages)
+	}
+
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("blocked sender is not resumed")
+	}
+}
+
+func TestBroadcastCopy(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clients := make([]*Client, 2)
+	for i := range clients {
+		serverConn, _ := net.Pipe()
+		if clients[i], err = NewClient(room, serverConn); err != nil {
+			t.Fatal(err)
+		}
+		if err := room.clientManager.Add(clients[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf := []byte{1}
+	room.SendToAllClients(0, buf)
+	buf[0] = 2
+
+	// The message is copied once and shared by the queues.
+	first, _ := clients[0].dequeue(clients[0].done)
+	second, _ := clients[1].dequeue(clients[1].done)
+	if !reflect.DeepEqual(first, [][]byte{{1}}) || &first[0][0] != &second[0][0] {
+		t.Errorf("invalid messages %v %v", first, second)
+	}
+}
+
+func TestDisconnectOnOverflow(t *testing.T) {
+	client := newQueueTestClient(t, DisconnectOnOverflow)
+
+	client.SendUnreliable([]byte{1})
+	client.Send([]byte{2})
+	client.SendUnreliable([]byte{3})
+	waitClientClosed(t, client)
+}
+
+func TestBlockOnOverflow(t *testing.T) {
+	client := newQueueTestClient(t, BlockOnOverflow)
+
+	client.Send([]byte{1})
+	client.Send([]byte{2})
+
+	sent := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			client.Send([]byte{3})
+			sent <- struct{}{}
+		}()
+	}
+
+	if _, ok := client.dequeue(client.done); !ok {
+		t.Fatal("failed to dequeue")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			t.Fatal("blocked sender is not resumed")
+		}
+	}
+
+	if metrics := client.SendQueueMetrics(); metrics.Depth != 2 || metrics.Dropped != 0 {
+		t.Errorf("invalid metrics %v", metrics)
+	}
+
+	client.Send([]byte{4})
+	waitClientClosed(t, client)
+}
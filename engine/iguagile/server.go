@@ -2,8 +2,11 @@ package iguagile
 
 import (
 	"bytes"
+	"compress/flate"
 	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,11 +14,13 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	pb "github.com/iguagile/iguagile-room-proto/room"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 // RoomServer is server manages rooms.
@@ -27,13 +32,108 @@ type RoomServer struct {
 	idGenerator          *IDGenerator
 	logger               *log.Logger
 	serverProto          *pb.Server
+	datagram             atomic.Pointer[datagramTransport]
 	RoomUpdateDuration   time.Duration
 	ServerUpdateDuration time.Duration
+
+	// HostElection elects a new host when the host leaves the room.
+	HostElection HostElection
+
+	// APITLSConfig enables TLS on the room service api if set.
+	APITLSConfig *tls.Config
+
+	// RequireTLS refuses the handshakes over the datagram transport, which
+	// would send the password and the resume token in plaintext. Set it when
+	// the room connections use TLS. Clients connected over TLS can still bind
+	// the datagram transport.
+	RequireTLS bool
+
+	// HandshakeTimeout is the time allowed for the client to complete the handshake.
+	HandshakeTimeout time.Duration
+
+	// MaxPendingHandshakes is the maximum number of handshakes in progress.
+	MaxPendingHandshakes int
+
+	// EmptyRoomTimeout is how long a room is kept after the last client leaves.
+	// The room is closed immediately if zero, and never if negative.
+	EmptyRoomTimeout time.Duration
+
+	// CreatorConnectTimeout is how long a room waits for the creator to connect
+	// before it is closed. Rooms wait forever if zero.
+	CreatorConnectTimeout time.Duration
+
+	// ResumeTimeout is how long a disconnected client is kept to resume the
+	// session. Clients are unregistered immediately if zero.
+	ResumeTimeout time.Duration
+
+	// MaxMissedMessages is the maximum number of messages kept for a
+	// disconnected client. The session is discarded if the client misses more.
+	MaxMissedMessages int
+
+	// SendQueueSize is the maximum number of messages waiting to be sent to a
+	// client.
+	SendQueueSize int
+
+	// SendQueuePolicy decides what happens when the send queue of a client is full.
+	SendQueuePolicy OverflowPolicy
+
+	// SendTimeout is how long the sender blocks on a full send queue with
+	// BlockOnOverflow.
+	SendTimeout time.Duration
+
+	// FlushInterval is the interval messages queued for a client are written
+	// together at. Queued messages are written as soon as possible if zero.
+	FlushInterval time.Duration
+
+	// MaxMessageSize is the default maximum size of a message reassembled from
+	// fragments in the rooms.
+	MaxMessageSize int
+
+	// Compressions are the compressions the clients can negotiate.
+	Compressions map[CompressionID]Compression
+
+	// CompressionThreshold is the minimum size of the messages compressed.
+	CompressionThreshold int
+
+	// PingInterval is the interval the clients are pinged at to measure the
+	// round trip time and keep the connections alive. The room handles Ping
+	// and Pong sent to Server only if it is set, and passes them to the
+	// service otherwise. Clients are not pinged if zero.
+	PingInterval time.Duration
+
+	// IdleTimeout is how long a client can be silent before the connection is
+	// closed. Clients answering the pings are never idle. Idle clients are
+	// kept if zero, which is the default since the clients not aware of the
+	// pings may be silent for long. Set PingInterval shorter than IdleTimeout
+	// as well, or quiet clients are closed while they are alive.
+	IdleTimeout time.Duration
+
+	// TickInterval is the interval rooms with a TickService tick at. The
+	// services of the rooms receive messages on the tick goroutine, and the
+	// messages sent to the clients are written at the end of each tick, or
+	// earlier when the send queue is full with BlockOnOverflow.
+	// Rooms do not tick if zero.
+	TickInterval time.Duration
+
+	// TickQueueSize is the maximum number of messages of a client waiting for
+	// the next tick. The client sending more is disconnected with
+	// DisconnectProtocolError. The messages are not limited if zero.
+	TickQueueSize int
+
+	pendingHandshakes int64
+
+	mutex        sync.Mutex
+	draining     bool
+	roomListener net.Listener
+	apiServer    *grpc.Server
 }
 
 // ErrPortIsOutOfRange is invalid ports request.
 var ErrPortIsOutOfRange = fmt.Errorf("port is out of range")
 
+// ErrServerClosed is returned after Shutdown is called.
+var ErrServerClosed = errors.New("room server closed")
+
 // NewRoomServer is a constructor of RoomServer.
 func NewRoomServer(factory RoomServiceFactory, store Store, address string) (*RoomServer, error) {
 	host, portStr, err := net.SplitHostPort(address)
@@ -68,16 +168,35 @@ func NewRoomServer(factory RoomServiceFactory, store Store, address string) (*Ro
 		return nil, err
 	}
 
+	deflate, err := NewDeflateCompression(flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+
 	return &RoomServer{
-		serverID:             serverID,
-		rooms:                &sync.Map{},
-		factory:              factory,
-		store:                store,
-		logger:               log.New(os.Stdout, "iguagile-server ", log.Lshortfile),
-		serverProto:          server,
-		RoomUpdateDuration:   time.Minute * 3,
-		ServerUpdateDuration: time.Minute * 3,
-		idGenerator:          idGenerator,
+		serverID:              serverID,
+		rooms:                 &sync.Map{},
+		factory:               factory,
+		store:                 store,
+		logger:                log.New(os.Stdout, "iguagile-server ", log.Lshortfile),
+		serverProto:           server,
+		RoomUpdateDuration:    time.Minute * 3,
+		ServerUpdateDuration:  time.Minute * 3,
+		HostElection:          ElectLongestConnected,
+		HandshakeTimeout:      time.Second * 10,
+		MaxPendingHandshakes:  1024,
+		CreatorConnectTimeout: time.Minute,
+		ResumeTimeout:         time.Second * 30,
+		MaxMissedMessages:     1024,
+		SendQueueSize:         1024,
+		SendQueuePolicy:       DropOldestUnreliable,
+		SendTimeout:           time.Second,
+		MaxMessageSize:        1024 * 1024,
+		Compressions:          map[CompressionID]Compression{CompressionDeflate: deflate},
+		CompressionThreshold:  128,
+		TickInterval:          time.Second / 30,
+		TickQueueSize:         1024,
+		idGenerator:           idGenerator,
 	}, nil
 }
 
@@ -88,12 +207,27 @@ func (s *RoomServer) Run(roomListener net.Listener, apiPort int) error {
 	}
 
 	s.serverProto.ApiPort = int32(apiPort)
-	server := grpc.NewServer()
+	var options []grpc.ServerOption
+	if s.APITLSConfig != nil {
+		options = append(options, grpc.Creds(credentials.NewTLS(s.APITLSConfig)))
+	}
+
+	server := grpc.NewServer(options...)
 	apiListener, err := net.Listen("tcp", fmt.Sprintf(":%v", apiPort))
 	if err != nil {
 		return err
 	}
 
+	s.mutex.Lock()
+	if s.draining {
+		s.mutex.Unlock()
+		_ = apiListener.Close()
+		return ErrServerClosed
+	}
+	s.roomListener = roomListener
+	s.apiServer = server
+	s.mutex.Unlock()
+
 	pb.RegisterRoomServiceServer(server, s)
 	go func() {
 		_ = server.Serve(apiListener)
@@ -121,6 +255,8 @@ func (s *RoomServer) Run(roomListener net.Listener, apiPort int) error {
 					if !ok {
 						return true
 					}
+					room.mutex.Lock()
+					defer room.mutex.Unlock()
 					if !room.creatorConnected {
 						return true
 					}
@@ -138,94 +274,259 @@ func (s *RoomServer) Run(roomListener net.Listener, apiPort int) error {
 	for {
 		conn, err := roomListener.Accept()
 		if err != nil {
+			if s.isDraining() {
+				return ErrServerClosed
+			}
 			s.logger.Println(err)
 			continue
 		}
 
-		if err := s.Serve(conn); err != nil {
-			s.logger.Println(err)
-		}
+		go func(conn net.Conn) {
+			if err := s.Serve(conn); err != nil {
+				s.logger.Println(err)
+				if err := conn.Close(); err != nil {
+					s.logger.Println(err)
+				}
+			}
+		}(conn)
 	}
 }
 
 // Serve handles requests from the peer.
+// The peer is told the result of the handshake, and the caller should close
+// the connection if an error is returned.
+//
+// The handshake must be completed in HandshakeTimeout, and the client is
+// rejected while MaxPendingHandshakes handshakes are in progress.
 func (s *RoomServer) Serve(conn io.ReadWriteCloser) error {
 	client := &Client{conn: conn}
+	room, options, err := s.timedHandshake(client)
+	if err == nil {
+		options.compression = s.negotiateCompression(options.compressions)
+		if options.resumeToken != nil {
+			err = room.resume(conn, options)
+		} else {
+			err = room.serve(conn, options)
+		}
+	}
+
+	var handshakeErr *HandshakeError
+	if errors.As(err, &handshakeErr) {
+		if err := client.write([]byte{byte(handshakeErr.Status)}); err != nil {
+			s.logger.Println(err)
+		}
+	}
+
+	return err
+}
+
+func (s *RoomServer) isDraining() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.draining
+}
+
+// Shutdown stops accepting new rooms and clients, unregisters the server and
+// notifies all clients with a ServerShutdown message carrying the milliseconds
+// until the deadline of the context. After all rooms become empty or the
+// context is done, all rooms are closed.
+func (s *RoomServer) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.draining = true
+	roomListener, apiServer := s.roomListener, s.apiServer
+	s.mutex.Unlock()
+
+	if roomListener != nil {
+		if err := roomListener.Close(); err != nil {
+			s.logger.Println(err)
+		}
+	}
+
+	if err := s.store.UnregisterServer(s.serverProto); err != nil {
+		s.logger.Println(err)
+	}
+
+	payload := make([]byte, 4)
+	if deadline, ok := ctx.Deadline(); ok {
+		binary.LittleEndian.PutUint32(payload, uint32(time.Until(deadline).Milliseconds()))
+	}
+
+	// The sessions can not be resumed any more since the handshakes are
+	// refused.
+	message := NewOutboundMessage(serverIDByte, ServerShutdown, payload)
+	for _, room := range s.getRooms() {
+		room.closeSuspendedSessions(DisconnectServerShutdown)
+		room.SendToAllClients(0, message)
+	}
+
+	err := s.waitEmptyRooms(ctx)
+	for _, room := range s.getRooms() {
+		if err := room.Close(); err != nil {
+			s.logger.Println(err)
+		}
+	}
+
+	if apiServer != nil {
+		apiServer.Stop()
+	}
+
+	return err
+}
+
+func (s *RoomServer) waitEmptyRooms(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+
+	for {
+		empty := true
+		for _, room := range s.getRooms() {
+			if room.clientManager.Count() > 0 {
+				empty = false
+				break
+			}
+		}
+
+		if empty {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *RoomServer) getRooms() []*Room {
+	rooms := make([]*Room, 0)
+	s.rooms.Range(func(_, value interface{}) bool {
+		if room, ok := value.(*Room); ok {
+			rooms = append(rooms, room)
+		}
+		return true
+	})
+	return rooms
+}
+
+func (s *RoomServer) timedHandshake(client *Client) (*Room, *handshakeOptions, error) {
+	if s.isDraining() {
+		return nil, nil, newHandshakeError(HandshakeServerClosed, ErrServerClosed)
+	}
+
+	defer atomic.AddInt64(&s.pendingHandshakes, -1)
+	if atomic.AddInt64(&s.pendingHandshakes, 1) > int64(s.MaxPendingHandshakes) {
+		return nil, nil, newHandshakeError(HandshakeServerBusy, ErrTooManyHandshakes)
+	}
+
+	timer := time.AfterFunc(s.HandshakeTimeout, func() {
+		_ = client.conn.Close()
+	})
+
+	room, options, err := s.handshake(client)
+	if !timer.Stop() {
+		return nil, nil, ErrHandshakeTimeout
+	}
+
+	return room, options, err
+}
+
+// handshake returns the room the client joins and the handshake options.
+func (s *RoomServer) handshake(client *Client) (*Room, *handshakeOptions, error) {
 	buf := make([]byte, maxMessageSize)
 	n, err := client.read(buf)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	if n != 4 {
-		return fmt.Errorf("invalid id length %v", buf[:n])
+	if n < 4 {
+		return nil, nil, newHandshakeError(HandshakeInvalidRequest, fmt.Errorf("invalid id length %v", buf[:n]))
+	}
+
+	options, err := parseHandshakeOptions(buf[4:n])
+	if err != nil {
+		return nil, nil, newHandshakeError(HandshakeInvalidRequest, err)
 	}
 
 	roomID := int(binary.LittleEndian.Uint32(buf[:4]))
 	r, ok := s.rooms.Load(roomID)
 	if !ok {
-		return fmt.Errorf("the room does not exist %v", roomID)
+		return nil, nil, newHandshakeError(HandshakeRoomNotFound, fmt.Errorf("the room does not exist %v", roomID))
 	}
 
 	room, ok := r.(*Room)
 	if !ok {
-		return fmt.Errorf("invalid type %T", r)
+		return nil, nil, newHandshakeError(HandshakeInternalError, fmt.Errorf("invalid type %T", r))
 	}
 
-	if room.clientManager.count >= room.config.MaxUser {
-		return fmt.Errorf("connected clients exceed room capacity %v %v", room.config.MaxUser, room.clientManager.count)
+	resuming := options.resumeToken != nil
+	if count := room.clientManager.Count(); !resuming && count >= room.config.MaxUser {
+		return nil, nil, newHandshakeError(HandshakeRoomFull, fmt.Errorf("%w %v %v", ErrRoomFull, room.config.MaxUser, count))
 	}
 
 	n, err = client.read(buf)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	applicationName := string(buf[:n])
 	if applicationName != room.config.ApplicationName {
-		return fmt.Errorf("invalid application name %v %v", applicationName, room.config.ApplicationName)
+		return nil, nil, newHandshakeError(HandshakeInvalidApplicationName, fmt.Errorf("invalid application name %v %v", applicationName, room.config.ApplicationName))
 	}
 
 	n, err = client.read(buf)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	version := string(buf[:n])
 	if version != room.config.Version {
-		return fmt.Errorf("invalid version %v %v", version, room.config.Version)
+		return nil, nil, newHandshakeError(HandshakeInvalidVersion, fmt.Errorf("invalid version %v %v", version, room.config.Version))
+	}
+
+	// The resume token stands for the password and the room token the client
+	// passed when it joined.
+	if resuming {
+		return room, options, nil
 	}
 
 	n, err = client.read(buf)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	password := string(buf[:n])
 	if room.config.Password != "" && password != room.config.Password {
-		return fmt.Errorf("invalid password %v %v", password, room.config.Password)
+		return nil, nil, newHandshakeError(HandshakeInvalidPassword, fmt.Errorf("invalid password %v %v", password, room.config.Password))
 	}
 
-	if !room.creatorConnected {
+	room.mutex.Lock()
+	creatorConnected := room.creatorConnected
+	room.mutex.Unlock()
+
+	if !creatorConnected {
 		n, err := client.read(buf)
 		if err != nil {
-			return err
+			return nil, nil, err
 		}
 
 		if !bytes.Equal(buf[:n], room.config.Token) {
-			return fmt.Errorf("invalid token %v %v", buf[:n], room.config.Token)
+			return nil, nil, newHandshakeError(HandshakeInvalidToken, fmt.Errorf("invalid token %v %v", buf[:n], room.config.Token))
 		}
 
+		room.mutex.Lock()
+		defer room.mutex.Unlock()
+
 		room.roomProto.ConnectedUser = 1
 
 		if err := s.store.RegisterRoom(room.roomProto); err != nil {
-			return err
+			return nil, nil, newHandshakeError(HandshakeInternalError, err)
 		}
 
 		room.creatorConnected = true
 	}
 
-	return room.serve(conn)
+	return room, options, nil
 }
 
 var errInvalidToken = fmt.Errorf("invalid room server api token")
@@ -236,6 +537,10 @@ func (s *RoomServer) CreateRoom(ctx context.Context, request *pb.CreateRoomReque
 		return nil, errInvalidToken
 	}
 
+	if s.isDraining() {
+		return nil, ErrServerClosed
+	}
+
 	roomID, err := s.idGenerator.Generate()
 	if err != nil {
 		return nil, err
@@ -251,6 +556,7 @@ func (s *RoomServer) CreateRoom(ctx context.Context, request *pb.CreateRoomReque
 		MaxUser:         int(request.MaxUser),
 		Token:           request.RoomToken,
 		Info:            request.Information,
+		MaxMessageSize:  s.MaxMessageSize,
 	}
 
 	r, err := newRoom(s, config)
@@ -263,8 +569,7 @@ func (s *RoomServer) CreateRoom(ctx context.Context, request *pb.CreateRoomReque
 		return nil, err
 	}
 	r.service = service
-
-	s.rooms.Store(roomID, r)
+	r.startTicks()
 
 	r.roomProto = &pb.Room{
 		RoomId:          int32(roomID),
@@ -277,5 +582,13 @@ func (s *RoomServer) CreateRoom(ctx context.Context, request *pb.CreateRoomReque
 		Information:     request.Information,
 	}
 
+	if s.CreatorConnectTimeout > 0 {
+		r.mutex.Lock()
+		r.creatorTimer = time.AfterFunc(s.CreatorConnectTimeout, r.closeIfCreatorAbsent)
+		r.mutex.Unlock()
+	}
+
+	s.rooms.Store(roomID, r)
+
 	return &pb.CreateRoomResponse{Room: r.roomProto}, nil
 }
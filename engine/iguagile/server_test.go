@@ -0,0 +1,119 @@
+package iguagile
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestShutdown(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- room.server.Shutdown(ctx)
+	}()
+
+	select {
+	case message := <-client.messages:
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.MessageType != ServerShutdown || len(data.Payload) != 4 {
+			t.Errorf("invalid shutdown message %v", message)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting shutdown message")
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go func() {
+		_ = room.server.Serve(serverConn)
+	}()
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(clientConn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || HandshakeStatus(buf[0]) != HandshakeServerClosed {
+		t.Errorf("invalid status get: %v, want: %v", buf[:n], HandshakeServerClosed)
+	}
+
+	if err := <-result; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("invalid error %v", err)
+	}
+
+	if _, ok := <-client.messages; ok {
+		t.Error("connection is not closed")
+	}
+
+	if _, ok := room.server.rooms.Load(roomID); ok {
+		t.Error("room is not closed")
+	}
+}
+
+func TestShutdownWithSuspendedClients(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.ResumeTimeout = time.Minute
+	room.server.MaxMissedMessages = 16
+
+	suspended, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = suspended.conn.Close()
+	waitSuspended(t, room, suspended.id)
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- room.server.Shutdown(ctx)
+	}()
+
+	// The client leaving after the shutdown message is not suspended, since
+	// it can not resume the session.
+	for message := range client.messages {
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data.MessageType == ServerShutdown {
+			break
+		}
+	}
+	_ = client.conn.Close()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("invalid error %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdown waits for the suspended clients")
+	}
+}
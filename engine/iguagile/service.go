@@ -1,5 +1,7 @@
 package iguagile
 
+import "time"
+
 // RoomService implements the processing performed by the room
 type RoomService interface {
 	// Receive processes data sent from the client to the server.
@@ -9,7 +11,8 @@ type RoomService interface {
 	OnRegisterClient(clientID int) error
 
 	// OnUnregisterClient is called when the client disconnects from the room
-	OnUnregisterClient(clientID int) error
+	// with the reason.
+	OnUnregisterClient(clientID int, reason DisconnectReason) error
 
 	// OnChangeHost is called when the host changes.
 	OnChangeHost(clientID int) error
@@ -18,6 +21,17 @@ type RoomService interface {
 	Destroy() error
 }
 
+// TickService is a RoomService driven by the tick loop of the room.
+// Receive and the other callbacks are called on the tick goroutine before
+// OnTick, so the service processes the room on a single goroutine.
+type TickService interface {
+	RoomService
+
+	// OnTick is called every TickInterval with the time elapsed since the
+	// last tick.
+	OnTick(dt time.Duration) error
+}
+
 // RoomServiceFactory creates RoomServices.
 type RoomServiceFactory interface {
 	// Create creates a RoomService.
@@ -41,7 +55,7 @@ func (s *RelayService) OnRegisterClient(_ int) error {
 }
 
 // OnUnregisterClient for implement RoomService.
-func (s *RelayService) OnUnregisterClient(_ int) error {
+func (s *RelayService) OnUnregisterClient(_ int, _ DisconnectReason) error {
 	return nil
 }
 
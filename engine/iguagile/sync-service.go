@@ -0,0 +1,597 @@
+package iguagile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// SyncService is a service synchronizes GameObjects between clients.
+//
+// Inbound messages are parsed by NewInBoundData and the payload of each
+// message type is as follows.
+//
+//	Instantiate       object id (4 bytes) | lifetime (1 byte) | resource path
+//	Destroy           object id (4 bytes)
+//	TransferOwnership object id (4 bytes) | new owner id (2 bytes)
+//	RequestOwnership  object id (4 bytes)
+//	GrantOwnership    object id (4 bytes) | requester id (2 bytes)
+//	DenyOwnership     object id (4 bytes) | requester id (2 bytes)
+//	Transform         object id (4 bytes) | transform
+//	RPC               object id (4 bytes) | rpc data
+//	Interest          area of interest (see parseInterestArea)
+//	Position          object id (4 bytes) | x, y, z (float32)
+//	JoinGroup         group id (2 bytes)
+//	LeaveGroup        group id (2 bytes)
+//	AckTransform      object id (4 bytes) | sequence (2 bytes)
+//
+// Ping and Pong are handled by the room if Server.PingInterval is set.
+// Otherwise Ping is answered the same way and Pong is ignored, so the clients
+// can measure the round trip time regardless of the server pings.
+//
+// RPCs sent to AllClientsBuffered or OtherClientsBuffered are buffered and
+// replayed to clients joining later in the order they were sent, until the
+// GameObject is destroyed.
+//
+// Transforms are sent over the datagram transport to the clients bound to it.
+//
+// Clients with the delta transform option of the handshake receive
+// TransformSnapshot, that is the object id, the sequence number (2 bytes) and
+// the transform, or TransformDelta against the transform acknowledged last,
+// that is the object id, the sequence number, the sequence number of the
+// baseline (2 bytes) and the changes (see encodeDelta). The clients answer
+// them with AckTransform. Clients joining later or entering the area of
+// interest of a GameObject catch up with TransformSnapshot as well.
+//
+// Transforms and RPCs can be sent to the members of a group with the group
+// targets. Clients join and leave groups with JoinGroup and LeaveGroup.
+//
+// Once the owner reports the position of a GameObject, its transforms and
+// RPCs sent to AllClients or OtherClients reach only the other clients whose
+// area of interest includes the position. A client entering the area of a
+// GameObject receives its latest transform.
+//
+// The transforms are recorded with the time they are received, so the room
+// can be rewound with Room.Rewind.
+//
+// Outbound messages have the same payload prefixed with the sender id and
+// the message type, except that RequestOwnership is sent only to the owner,
+// DenyOwnership is sent only to the requester with the object id, and
+// GrantOwnership is announced to all clients as TransferOwnership.
+//
+// When the owner leaves the room, GameObjects with the ownerExist lifetime
+// are destroyed and the others are transferred to the host.
+type SyncService struct {
+	room *Room
+}
+
+// ErrInvalidTarget is when given unknown target.
+var ErrInvalidTarget = fmt.Errorf("invalid target")
+
+// Receive processes the message sent from the client.
+func (s *SyncService) Receive(senderID int, data []byte) error {
+	binaryData, err := NewInBoundData(data)
+	if err != nil {
+		return err
+	}
+
+	sender, err := s.room.clientManager.Get(senderID)
+	if err != nil {
+		return err
+	}
+
+	switch binaryData.MessageType {
+	case Instantiate:
+		return s.instantiate(sender, binaryData.Payload)
+	case Destroy:
+		return s.destroy(sender, binaryData.Payload)
+	case TransferOwnership:
+		return s.transferOwnership(sender, binaryData.Payload)
+	case RequestOwnership:
+		return s.requestOwnership(sender, binaryData.Payload)
+	case GrantOwnership, DenyOwnership:
+		return s.answerOwnershipRequest(sender, binaryData.MessageType, binaryData.Payload)
+	case Transform:
+		return s.transform(sender, binaryData.Target, binaryData.Group, binaryData.Payload)
+	case RPC:
+		return s.rpc(sender, binaryData.Target, binaryData.Group, binaryData.Payload)
+	case Interest:
+		return s.interest(sender, binaryData.Payload)
+	case Position:
+		return s.position(sender, binaryData.Payload)
+	case JoinGroup, LeaveGroup:
+		return s.changeGroup(sender, binaryData.MessageType, binaryData.Payload)
+	case AckTransform:
+		return s.ackTransform(sender, binaryData.Payload)
+	case Ping:
+		sender.answerPing(time.Now(), binaryData.Payload)
+		return nil
+	case Pong:
+		return nil
+	default:
+		return fmt.Errorf("invalid message type %v", binaryData.MessageType)
+	}
+}
+
+func (s *SyncService) instantiate(sender *Client, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) <= objectIDSize {
+		return ErrInvalidDataFormat
+	}
+
+	lifetime := payload[objectIDSize]
+	if lifetime != roomExist && lifetime != ownerExist {
+		return fmt.Errorf("invalid lifetime %v", lifetime)
+	}
+
+	resourcePath := make([]byte, len(payload)-objectIDSize-1)
+	copy(resourcePath, payload[objectIDSize+1:])
+
+	gameObject := &GameObject{
+		id:           objectID,
+		owner:        sender,
+		lifetime:     lifetime,
+		resourcePath: resourcePath,
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	if err := s.room.gameObjectManager.Add(gameObject); err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	batch.sendAll(s.room.gameObjectManager.syncedClients(), NewOutboundMessage(sender.idByte, Instantiate, payload), true)
+	return nil
+}
+
+func (s *SyncService) destroy(sender *Client, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if gameObject.owner != sender {
+		s.room.log.Printf("client %v is not the owner of object %v\n", sender.id, objectID)
+		return nil
+	}
+
+	s.room.gameObjectManager.Remove(objectID)
+	s.room.ClearRPCBuffer(objectID)
+	batch.sendAll(s.room.gameObjectManager.syncedClients(), NewOutboundMessage(sender.idByte, Destroy, payload[:objectIDSize]), true)
+	return nil
+}
+
+func (s *SyncService) transferOwnership(sender *Client, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) < objectIDSize+2 {
+		return ErrInvalidDataFormat
+	}
+
+	newOwnerID := int(binary.LittleEndian.Uint16(payload[objectIDSize:]))
+	newOwner, err := s.room.clientManager.Get(newOwnerID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if gameObject.owner != sender {
+		s.room.log.Printf("client %v is not the owner of object %v\n", sender.id, objectID)
+		return nil
+	}
+
+	s.room.transferGameObject(&batch, gameObject, sender, newOwner)
+	return nil
+}
+
+func (s *SyncService) requestOwnership(sender *Client, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if gameObject.owner == nil || gameObject.owner == sender {
+		return nil
+	}
+
+	gameObject.addRequester(sender.id)
+	batch.send(gameObject.owner, NewOutboundMessage(sender.idByte, RequestOwnership, payload[:objectIDSize]), true)
+	return nil
+}
+
+func (s *SyncService) answerOwnershipRequest(sender *Client, messageType byte, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) < objectIDSize+2 {
+		return ErrInvalidDataFormat
+	}
+
+	requesterID := int(binary.LittleEndian.Uint16(payload[objectIDSize:]))
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if gameObject.owner != sender {
+		s.room.log.Printf("client %v is not the owner of object %v\n", sender.id, objectID)
+		return nil
+	}
+
+	if !gameObject.removeRequester(requesterID) {
+		s.room.log.Printf("client %v does not request the ownership of object %v\n", requesterID, objectID)
+		return nil
+	}
+
+	requester, err := s.room.clientManager.Get(requesterID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if messageType == GrantOwnership {
+		s.room.transferGameObject(&batch, gameObject, sender, requester)
+		return nil
+	}
+
+	batch.send(requester, NewOutboundMessage(sender.idByte, DenyOwnership, payload[:objectIDSize]), true)
+	return nil
+}
+
+func (s *SyncService) transform(sender *Client, target byte, group int, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if gameObject.owner != sender {
+		s.room.log.Printf("client %v is not the owner of object %v\n", sender.id, objectID)
+		return nil
+	}
+
+	transform := make([]byte, len(payload)-objectIDSize)
+	copy(transform, payload[objectIDSize:])
+	gameObject.updateTransform(transform, time.Now())
+
+	recipients, reliable, err := s.recipients(sender, target, group, gameObject.position)
+	if err != nil {
+		return err
+	}
+
+	message := NewOutboundMessage(sender.idByte, Transform, payload)
+	for _, client := range recipients {
+		message := message
+		if client.deltaTransforms.Load() {
+			message = transformMessage(sender.idByte, gameObject, client)
+		}
+
+		batch.send(client, message, reliable)
+	}
+
+	return nil
+}
+
+// recipients returns the clients the message about the GameObject at the
+// position is sent to with the target, and whether a transform to them is
+// sent reliably. AllClients and OtherClients reach only the clients
+// interested in the position, and the clients not caught up with the room are
+// left out. The caller must hold the lock of the GameObjectManager.
+func (s *SyncService) recipients(sender *Client, target byte, group int, position *Vector3) ([]*Client, bool, error) {
+	var clients []*Client
+	switch target {
+	case AllClients, AllClientsBuffered, OtherClients, OtherClientsBuffered:
+		clients = s.room.gameObjectManager.syncedClients()
+	case GroupClients, OtherGroupClients:
+		clients = s.room.groupManager.Members(group)
+	case Host:
+		if host := s.room.GetHost(); host != nil && s.room.gameObjectManager.isSynced(host) {
+			return []*Client{host}, true, nil
+		}
+		return nil, true, nil
+	case Server:
+		return nil, false, nil
+	default:
+		return nil, false, ErrInvalidTarget
+	}
+
+	others := target == OtherClients || target == OtherClientsBuffered || target == OtherGroupClients
+	interest := position != nil && (target == AllClients || target == OtherClients)
+	recipients := clients[:0]
+	for _, client := range clients {
+		if !s.room.gameObjectManager.isSynced(client) {
+			continue
+		}
+
+		if client == sender {
+			if !others {
+				recipients = append(recipients, client)
+			}
+			continue
+		}
+
+		if interest && !client.isInterested(*position) {
+			continue
+		}
+		recipients = append(recipients, client)
+	}
+
+	return recipients, false, nil
+}
+
+func (s *SyncService) ackTransform(sender *Client, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) < objectIDSize+2 {
+		return ErrInvalidDataFormat
+	}
+
+	sequence := binary.LittleEndian.Uint16(payload[objectIDSize:])
+
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if !gameObject.ackTransform(sender.id, sequence) {
+		s.room.log.Printf("unknown transform %v of object %v\n", sequence, objectID)
+	}
+	return nil
+}
+
+func (s *SyncService) rpc(sender *Client, target byte, group int, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Printf("object not exists %v\n", objectID)
+		return nil
+	}
+
+	recipients, _, err := s.recipients(sender, target, group, gameObject.position)
+	if err != nil {
+		return err
+	}
+
+	message := NewOutboundMessage(sender.idByte, RPC, payload)
+	if target == AllClientsBuffered || target == OtherClientsBuffered {
+		s.room.rpcBufferManager.Add(objectID, message, sender)
+	}
+
+	batch.sendAll(recipients, message, true)
+	return nil
+}
+
+func (s *SyncService) changeGroup(sender *Client, messageType byte, payload []byte) error {
+	groupID, err := readGroupID(payload)
+	if err != nil {
+		return err
+	}
+
+	if messageType == JoinGroup {
+		s.room.groupManager.Subscribe(groupID, sender)
+	} else {
+		s.room.groupManager.Unsubscribe(groupID, sender.id)
+	}
+	return nil
+}
+
+func (s *SyncService) interest(sender *Client, payload []byte) error {
+	area, err := parseInterestArea(payload)
+	if err != nil {
+		return err
+	}
+
+	old := sender.Interest()
+	sender.SetInterest(area)
+	if old == nil {
+		return nil
+	}
+
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	for _, gameObject := range s.room.gameObjectManager.GetAllGameObjects() {
+		position := gameObject.position
+		if position == nil || gameObject.transform == nil || gameObject.owner == sender || gameObject.owner == nil {
+			continue
+		}
+
+		if old.Contains(*position) || !sender.isInterested(*position) {
+			continue
+		}
+
+		batch.send(sender, catchUpTransform(gameObject.owner.idByte, gameObject, sender), true)
+	}
+
+	return nil
+}
+
+func (s *SyncService) position(sender *Client, payload []byte) error {
+	objectID, err := readObjectID(payload)
+	if err != nil {
+		return err
+	}
+
+	position, err := parsePosition(payload[objectIDSize:])
+	if err != nil {
+		return err
+	}
+
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	gameObject, err := s.room.gameObjectManager.Get(objectID)
+	if err != nil {
+		s.room.log.Println(err)
+		return nil
+	}
+
+	if gameObject.owner != sender {
+		s.room.log.Printf("client %v is not the owner of object %v\n", sender.id, objectID)
+		return nil
+	}
+
+	gameObject.position = &position
+	return nil
+}
+
+// OnRegisterClient notifies the connection to other clients and sends the
+// current state of the room to the new client.
+func (s *SyncService) OnRegisterClient(clientID int) error {
+	client, err := s.room.clientManager.Get(clientID)
+	if err != nil {
+		return err
+	}
+
+	s.room.SendToOtherClients(clientID, NewOutboundMessage(client.idByte, NewConnect, nil))
+
+	for _, other := range s.room.clientManager.Clients() {
+		if other.id != clientID {
+			client.Send(NewOutboundMessage(other.idByte, NewConnect, nil))
+		}
+	}
+
+	s.sendRoomState(client)
+	return nil
+}
+
+// sendRoomState queues the GameObjects and the buffered RPCs to the client
+// under the lock, so that the changes and RPCs after that follow them and the
+// ones before that are not sent again.
+func (s *SyncService) sendRoomState(client *Client) {
+	var batch sendBatch
+	defer batch.wait()
+	s.room.gameObjectManager.Lock()
+	defer s.room.gameObjectManager.Unlock()
+
+	for _, gameObject := range s.room.gameObjectManager.GetAllGameObjects() {
+		// GameObjects left without owner are sent by the server.
+		ownerIDByte := serverIDByte
+		if gameObject.owner != nil {
+			ownerIDByte = gameObject.owner.idByte
+		}
+
+		objectID := make([]byte, objectIDSize)
+		binary.LittleEndian.PutUint32(objectID, uint32(gameObject.id))
+
+		payload := make([]byte, 0, objectIDSize+len(gameObject.resourcePath)+1)
+		payload = append(payload, objectID...)
+		payload = append(payload, gameObject.lifetime)
+		payload = append(payload, gameObject.resourcePath...)
+		batch.send(client, NewOutboundMessage(ownerIDByte, Instantiate, payload), true)
+
+		if gameObject.transform != nil {
+			batch.send(client, catchUpTransform(ownerIDByte, gameObject, client), true)
+		}
+	}
+	s.room.rpcBufferManager.sendRPCBuffer(&batch, client)
+	s.room.gameObjectManager.sync(client)
+}
+
+// OnUnregisterClient for implement RoomService.
+func (s *SyncService) OnUnregisterClient(_ int, _ DisconnectReason) error {
+	return nil
+}
+
+// OnChangeHost for implement RoomService.
+func (s *SyncService) OnChangeHost(_ int) error {
+	return nil
+}
+
+// Destroy clears all GameObjects.
+func (s *SyncService) Destroy() error {
+	s.room.gameObjectManager.Lock()
+	s.room.gameObjectManager.Clear()
+	s.room.gameObjectManager.Unlock()
+	s.room.rpcBufferManager.Clear()
+	return nil
+}
+
+// SyncServiceFactory creates SyncService.
+type SyncServiceFactory struct{}
+
+// Create creates a SyncService.
+func (f SyncServiceFactory) Create(room *Room) (RoomService, error) {
+	return &SyncService{room: room}, nil
+}
@@ -0,0 +1,502 @@
+package iguagile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/iguagile/iguagile-room-proto/room"
+)
+
+type testStore struct{}
+
+func (testStore) Close() error                      { return nil }
+func (testStore) GenerateServerID() (int, error)    { return serverID, nil }
+func (testStore) RegisterServer(*pb.Server) error   { return nil }
+func (testStore) UnregisterServer(*pb.Server) error { return nil }
+func (testStore) RegisterRoom(*pb.Room) error       { return nil }
+func (testStore) UnregisterRoom(*pb.Room) error     { return nil }
+
+func newTestRoom(factory RoomServiceFactory) (*Room, error) {
+	idGenerator, err := NewIDGenerator()
+	if err != nil {
+		return nil, err
+	}
+
+	server := &RoomServer{
+		idGenerator:          idGenerator,
+		rooms:                &sync.Map{},
+		store:                testStore{},
+		logger:               log.New(os.Stdout, "iguagile-server ", log.Lshortfile),
+		HostElection:         ElectLongestConnected,
+		HandshakeTimeout:     time.Second,
+		MaxPendingHandshakes: 16,
+		SendQueueSize:        64,
+	}
+
+	room, err := newRoom(server, &RoomConfig{
+		RoomID:          roomID,
+		ApplicationName: appName,
+		Version:         appVersion,
+		Password:        password,
+		MaxUser:         10,
+		Token:           roomToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+	server.rooms.Store(roomID, room)
+
+	service, err := factory.Create(room)
+	if err != nil {
+		return nil, err
+	}
+	room.service = service
+
+	return room, nil
+}
+
+type testClient struct {
+	conn        net.Conn
+	id          int
+	messages    chan []byte
+	resumeToken []byte
+}
+
+func joinTestRoom(room *Room) (*testClient, error) {
+	serverConn, clientConn := net.Pipe()
+	client, err := NewClient(room, serverConn)
+	if err != nil {
+		return nil, err
+	}
+
+	return registerTestClient(room, client, clientConn)
+}
+
+// registerTestClient registers the client connected to clientConn, which is
+// the other end of the pipe given to NewClient.
+func registerTestClient(room *Room, client *Client, clientConn net.Conn) (*testClient, error) {
+	c := &testClient{conn: clientConn, id: client.id, messages: make(chan []byte, 64)}
+	go func() {
+		for {
+			buf := make([]byte, maxMessageSize)
+			n, err := receive(clientConn, buf)
+			if err != nil {
+				close(c.messages)
+				return
+			}
+			c.messages <- buf[:n]
+		}
+	}()
+
+	if err := room.register(client); err != nil {
+		return nil, err
+	}
+
+	select {
+	case message := <-c.messages:
+		if !bytes.Equal(message, handshakeSucceeded(client, room.GetHost(), CompressionNone)) {
+			return nil, fmt.Errorf("invalid handshake response %v", message)
+		}
+		c.resumeToken = message[5 : 5+resumeTokenSize]
+	case <-time.After(time.Second):
+		return nil, fmt.Errorf("timeout waiting handshake response")
+	}
+
+	return c, nil
+}
+
+func (c *testClient) expect(t *testing.T, senderID int, messageType byte, payload []byte) {
+	t.Helper()
+	select {
+	case message := <-c.messages:
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if int(binary.LittleEndian.Uint16(data.ID)) != senderID {
+			t.Errorf("invalid sender get: %v, want: %v", data.ID, senderID)
+		}
+		if data.MessageType != messageType {
+			t.Errorf("invalid message type get: %v, want: %v", data.MessageType, messageType)
+		}
+		if !bytes.Equal(data.Payload, payload) {
+			t.Errorf("invalid payload get: %v, want: %v", data.Payload, payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting message type %v", messageType)
+	}
+}
+
+func objectPayload(objectID int, data ...byte) []byte {
+	payload := make([]byte, objectIDSize, objectIDSize+len(data))
+	binary.LittleEndian.PutUint32(payload, uint32(objectID))
+	return append(payload, data...)
+}
+
+func TestSyncService(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instantiate := objectPayload(1, append([]byte{ownerExist}, "player"...)...)
+	if err := send(first.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, Instantiate, instantiate)
+
+	transform := objectPayload(1, 1, 2, 3)
+	if err := send(first.conn, append([]byte{Server, Transform}, transform...)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for the transform to be processed before the second client joins.
+	rpc := objectPayload(1, 4)
+	if err := send(first.conn, append([]byte{AllClients, RPC}, rpc...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, RPC, rpc)
+
+	buffered := [][]byte{objectPayload(1, 5), objectPayload(1, 6), objectPayload(1, 7)}
+	for i, target := range []byte{OtherClientsBuffered, AllClientsBuffered, OtherClientsBuffered} {
+		if err := send(first.conn, append([]byte{target, RPC}, buffered[i]...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first.expect(t, first.id, RPC, buffered[1])
+
+	second, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first.expect(t, second.id, NewConnect, nil)
+	second.expect(t, first.id, NewConnect, nil)
+	second.expect(t, first.id, Instantiate, instantiate)
+	second.expect(t, first.id, Transform, transform)
+	for _, payload := range buffered {
+		second.expect(t, first.id, RPC, payload)
+	}
+
+	if err := send(second.conn, append([]byte{OtherClients, Destroy}, objectPayload(1)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	transfer := objectPayload(1, encodeClientID(second.id)...)
+	if err := send(first.conn, append([]byte{AllClients, TransferOwnership}, transfer...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, first.id, TransferOwnership, transfer)
+	second.expect(t, first.id, TransferOwnership, transfer)
+
+	if err := send(second.conn, append([]byte{AllClients, Destroy}, objectPayload(1)...)); err != nil {
+		t.Fatal(err)
+	}
+	first.expect(t, second.id, Destroy, objectPayload(1))
+	second.expect(t, second.id, Destroy, objectPayload(1))
+
+	room.rpcBufferManager.Lock()
+	if n := len(room.rpcBufferManager.buffer); n != 0 {
+		t.Errorf("rpc buffer of destroyed object remains %v", n)
+	}
+	room.rpcBufferManager.Unlock()
+}
+
+func TestSyncServiceOwnership(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, guest.id, NewConnect, nil)
+	guest.expect(t, host.id, NewConnect, nil)
+
+	roomObject := objectPayload(1, roomExist)
+	if err := send(host.conn, append([]byte{AllClients, Instantiate}, roomObject...)); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, host.id, Instantiate, roomObject)
+	guest.expect(t, host.id, Instantiate, roomObject)
+
+	ownerObject := objectPayload(2, ownerExist)
+	if err := send(guest.conn, append([]byte{AllClients, Instantiate}, ownerObject...)); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, guest.id, Instantiate, ownerObject)
+	guest.expect(t, guest.id, Instantiate, ownerObject)
+
+	if err := send(guest.conn, append([]byte{Server, RequestOwnership}, objectPayload(1)...)); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, guest.id, RequestOwnership, objectPayload(1))
+
+	answer := objectPayload(1, encodeClientID(guest.id)...)
+	if err := send(host.conn, append([]byte{Server, DenyOwnership}, answer...)); err != nil {
+		t.Fatal(err)
+	}
+	guest.expect(t, host.id, DenyOwnership, objectPayload(1))
+
+	if err := send(guest.conn, append([]byte{Server, RequestOwnership}, objectPayload(1)...)); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, guest.id, RequestOwnership, objectPayload(1))
+
+	if err := send(host.conn, append([]byte{Server, GrantOwnership}, answer...)); err != nil {
+		t.Fatal(err)
+	}
+	host.expect(t, host.id, TransferOwnership, answer)
+	guest.expect(t, host.id, TransferOwnership, answer)
+
+	if err := guest.conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The guest's GameObjects are released in no particular order.
+	released := map[byte][]byte{}
+	for i := 0; i < 2; i++ {
+		select {
+		case message := <-host.messages:
+			data, err := NewOutBoundData(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			released[data.MessageType] = data.Payload
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting released objects")
+		}
+	}
+
+	if !bytes.Equal(released[Destroy], objectPayload(2)) {
+		t.Errorf("invalid destroy get: %v, want: %v", released[Destroy], objectPayload(2))
+	}
+	if want := objectPayload(1, encodeClientID(host.id)...); !bytes.Equal(released[TransferOwnership], want) {
+		t.Errorf("invalid transfer get: %v, want: %v", released[TransferOwnership], want)
+	}
+
+	host.expect(t, guest.id, ExitConnect, []byte{byte(DisconnectLeft)})
+}
+
+func TestSyncServiceOwnerlessObject(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := joinTestRoom(room); err != nil {
+		t.Fatal(err)
+	}
+
+	// The host adopts ownerless GameObjects only when it joins.
+	gameObject := &GameObject{id: 1, lifetime: roomExist, resourcePath: []byte("door")}
+	if err := room.gameObjectManager.Add(gameObject); err != nil {
+		t.Fatal(err)
+	}
+
+	guest, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest.skip(t, 1)
+
+	serverID := int(binary.LittleEndian.Uint16(serverIDByte))
+	guest.expect(t, serverID, Instantiate, objectPayload(1, append([]byte{roomExist}, "door"...)...))
+}
+
+// checkRoomState follows the GameObjects the client is told about, and
+// reports an error if they are out of order, or nil once the last object is
+// instantiated with no other objects left.
+func checkRoomState(c *testClient, last uint32) error {
+	objects := make(map[uint32]bool)
+	for message := range c.messages {
+		data, err := NewOutBoundData(message)
+		if err != nil {
+			return err
+		}
+
+		switch data.MessageType {
+		case Instantiate:
+			id := binary.LittleEndian.Uint32(data.Payload)
+			if objects[id] {
+				return fmt.Errorf("object %v is instantiated twice", id)
+			}
+			objects[id] = true
+			if id == last {
+				if len(objects) != 1 {
+					return fmt.Errorf("objects %v are not destroyed", objects)
+				}
+				return nil
+			}
+		case Destroy:
+			id := binary.LittleEndian.Uint32(data.Payload)
+			if !objects[id] {
+				return fmt.Errorf("object %v is destroyed before instantiated", id)
+			}
+			delete(objects, id)
+		}
+	}
+
+	return fmt.Errorf("client %v is disconnected", c.id)
+}
+
+func TestSyncServiceJoinRace(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.SendQueueSize = 1024
+
+	host, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range host.messages {
+		}
+	}()
+
+	const objects = 200
+	sent := make(chan error, 1)
+	go func() {
+		for i := 1; i <= objects; i++ {
+			instantiate := objectPayload(i, ownerExist)
+			if err := send(host.conn, append([]byte{AllClients, Instantiate}, instantiate...)); err != nil {
+				sent <- err
+				return
+			}
+			if err := send(host.conn, append([]byte{AllClients, Destroy}, objectPayload(i)...)); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	results := make(chan error, 8)
+	for i := 0; i < cap(results); i++ {
+		client, err := joinTestRoom(room)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			results <- checkRoomState(client, objects+1)
+		}()
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	last := objectPayload(objects+1, ownerExist)
+	if err := send(host.conn, append([]byte{AllClients, Instantiate}, last...)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < cap(results); i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting room state")
+		}
+	}
+}
+
+func TestSyncServiceBufferedRPCJoinRace(t *testing.T) {
+	room, err := newTestRoom(SyncServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.SendQueueSize = 1024
+
+	host, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range host.messages {
+		}
+	}()
+
+	if err := send(host.conn, append([]byte{AllClients, Instantiate}, objectPayload(1, roomExist)...)); err != nil {
+		t.Fatal(err)
+	}
+
+	const rpcs = 200
+	sent := make(chan error, 1)
+	go func() {
+		for i := 1; i <= rpcs; i++ {
+			rpc := objectPayload(1, binary.LittleEndian.AppendUint16(nil, uint16(i))...)
+			if err := send(host.conn, append([]byte{OtherClientsBuffered, RPC}, rpc...)); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+
+	// Each client receives every buffered rpc once, live or replayed.
+	results := make(chan error, 8)
+	for i := 0; i < cap(results); i++ {
+		client, err := joinTestRoom(room)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			next := uint16(1)
+			for message := range client.messages {
+				data, err := NewOutBoundData(message)
+				if err != nil || data.MessageType != RPC {
+					continue
+				}
+				if sequence := binary.LittleEndian.Uint16(data.Payload[objectIDSize:]); sequence != next {
+					results <- fmt.Errorf("invalid rpc get: %v, want: %v", sequence, next)
+					return
+				}
+				if next == rpcs {
+					results <- nil
+					return
+				}
+				next++
+			}
+			results <- fmt.Errorf("client %v is disconnected", client.id)
+		}()
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cap(results); i++ {
+		select {
+		case err := <-results:
+			if err != nil {
+				t.Error(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("timeout waiting rpcs")
+		}
+	}
+}
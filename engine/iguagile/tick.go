@@ -0,0 +1,179 @@
+package iguagile
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tickLoop runs the TickService of the room on a single goroutine.
+type tickLoop struct {
+	service  TickService
+	interval time.Duration
+	events   []func()
+	stopped  bool
+	mutex    sync.Mutex
+	stop     chan struct{}
+}
+
+// startTicks runs the room in the tick mode if the service is a TickService
+// and TickInterval is set.
+func (r *Room) startTicks() {
+	service, ok := r.service.(TickService)
+	if !ok || r.server.TickInterval <= 0 {
+		return
+	}
+
+	r.ticker = &tickLoop{
+		service:  service,
+		interval: r.server.TickInterval,
+		stop:     make(chan struct{}),
+	}
+	go r.runTicks()
+}
+
+// stopTicks stops the tick loop. The loop processes the pending events,
+// flushes the messages and destroys the service on the way out. Events posted
+// after that are discarded.
+func (r *Room) stopTicks() {
+	r.ticker.mutex.Lock()
+	defer r.ticker.mutex.Unlock()
+	if !r.ticker.stopped {
+		r.ticker.stopped = true
+		close(r.ticker.stop)
+	}
+}
+
+func (r *Room) runTicks() {
+	ticker := time.NewTicker(r.ticker.interval)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			r.runEvents()
+			if err := r.ticker.service.OnTick(now.Sub(last)); err != nil {
+				r.log.Println(err)
+			}
+			last = now
+			r.flushClients()
+		case <-r.ticker.stop:
+			r.runEvents()
+			r.flushClients()
+			if err := r.ticker.service.Destroy(); err != nil {
+				r.log.Println(err)
+			}
+			return
+		}
+	}
+}
+
+// post queues the event processed at the beginning of the next tick.
+func (l *tickLoop) post(event func()) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if !l.stopped {
+		l.events = append(l.events, event)
+	}
+}
+
+// runEvents processes the events queued until now in order.
+func (r *Room) runEvents() {
+	r.ticker.mutex.Lock()
+	events := r.ticker.events
+	r.ticker.events = nil
+	r.ticker.mutex.Unlock()
+
+	for _, event := range events {
+		event()
+	}
+}
+
+// flushClients lets the clients write the messages sent during the tick.
+func (r *Room) flushClients() {
+	for _, client := range r.clientManager.Clients() {
+		select {
+		case client.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// dispatch calls the service, on the tick goroutine in the tick mode.
+func (r *Room) dispatch(call func() error) error {
+	if r.ticker == nil {
+		return call()
+	}
+
+	r.ticker.post(func() {
+		if err := call(); err != nil {
+			r.log.Println(err)
+		}
+	})
+	return nil
+}
+
+// ErrTickQueueOverflow is when the client sends more messages than the room
+// processes in a tick.
+var ErrTickQueueOverflow = errors.New("tick queue overflow")
+
+// receive passes the message from the client to the service, except for
+// the messages the engine handles itself. In the tick
+// mode the message is queued, and the client is disconnected on the tick if
+// the service fails to process it, or at once if it has TickQueueSize
+// messages queued.
+func (r *Room) receive(client *Client, message []byte) error {
+	client.touch()
+	if len(message) >= 2 && message[0] == Server && message[1] == Leave {
+		r.CloseConnection(client, DisconnectLeft)
+		return nil
+	}
+
+	if ok, err := client.receivePing(message); ok {
+		return err
+	}
+
+	if r.ticker == nil {
+		return r.service.Receive(client.id, message)
+	}
+
+	queued := atomic.AddInt64(&client.tickEvents, 1)
+	if limit := r.server.TickQueueSize; limit > 0 && queued > int64(limit) {
+		atomic.AddInt64(&client.tickEvents, -1)
+		return ErrTickQueueOverflow
+	}
+
+	// The buffer of the message is reused by the reader.
+	data := append([]byte{}, message...)
+	r.ticker.post(func() {
+		atomic.AddInt64(&client.tickEvents, -1)
+		if client.isClosed() {
+			return
+		}
+
+		if err := r.service.Receive(client.id, data); err != nil {
+			r.log.Println(err)
+			r.CloseConnection(client, DisconnectProtocolError)
+		}
+	})
+	return nil
+}
+
+// flushLoop writes the queued messages when the room flushes them at the end
+// of each tick.
+func (c *Client) flushLoop(conn io.Writer, done chan struct{}, compression Compression) {
+	for {
+		select {
+		case <-c.flush:
+		case <-done:
+			return
+		}
+
+		if messages := c.takeQueue(done); messages != nil && !c.writeMessages(conn, done, compression, messages) {
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package iguagile
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+type tickTestService struct {
+	room      *Room
+	ticks     int
+	received  [][]byte
+	destroyed chan struct{}
+}
+
+func (s *tickTestService) Receive(_ int, data []byte) error {
+	s.received = append(s.received, data)
+	return nil
+}
+
+func (s *tickTestService) OnRegisterClient(_ int) error { return nil }
+func (s *tickTestService) OnUnregisterClient(_ int, _ DisconnectReason) error {
+	return nil
+}
+func (s *tickTestService) OnChangeHost(_ int) error { return nil }
+
+func (s *tickTestService) OnTick(dt time.Duration) error {
+	s.ticks++
+	if dt <= 0 {
+		s.room.log.Printf("invalid tick %v\n", dt)
+	}
+
+	for _, data := range s.received {
+		s.room.SendToAllClients(0, NewOutboundMessage(encodeClientID(0), RPC, data))
+	}
+	s.received = nil
+	return nil
+}
+
+func (s *tickTestService) Destroy() error {
+	close(s.destroyed)
+	return nil
+}
+
+type tickTestServiceFactory struct {
+	service *tickTestService
+}
+
+func (f tickTestServiceFactory) Create(room *Room) (RoomService, error) {
+	f.service.room = room
+	return f.service, nil
+}
+
+func TestTickBlockOnOverflow(t *testing.T) {
+	service := &tickTestService{destroyed: make(chan struct{})}
+	room, err := newTestRoom(tickTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.TickInterval = time.Hour
+	room.server.SendQueueSize = 2
+	room.server.SendQueuePolicy = BlockOnOverflow
+	room.server.SendTimeout = time.Second
+	room.startTicks()
+
+	serverConn, clientConn := net.Pipe()
+	client, err := NewClient(room, serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go client.writeStart(serverConn, client.done, nil)
+	go func() {
+		for {
+			if _, err := receive(clientConn, make([]byte, maxMessageSize)); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		client.Send([]byte{byte(i)})
+	}
+	if elapsed := time.Since(start); elapsed >= room.server.SendTimeout/2 {
+		t.Errorf("sender is blocked for %v", elapsed)
+	}
+	if client.isClosed() {
+		t.Error("client is disconnected")
+	}
+}
+
+func TestTickService(t *testing.T) {
+	service := &tickTestService{destroyed: make(chan struct{})}
+	room, err := newTestRoom(tickTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.TickInterval = time.Millisecond * 10
+	room.startTicks()
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range [][]byte{{1}, {2}, {3}} {
+		if err := send(client.conn, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, data := range [][]byte{{1}, {2}, {3}} {
+		client.expect(t, 0, RPC, data)
+	}
+
+	if err := room.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-service.destroyed:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting destroy")
+	}
+	if service.ticks == 0 {
+		t.Error("room did not tick")
+	}
+}
+
+func TestTickQueueOverflow(t *testing.T) {
+	service := &tickTestService{destroyed: make(chan struct{})}
+	room, err := newTestRoom(tickTestServiceFactory{service: service})
+	if err != nil {
+		t.Fatal(err)
+	}
+	room.server.TickInterval = time.Hour
+	room.server.TickQueueSize = 2
+	room.startTicks()
+	defer func() { _ = room.Close() }()
+
+	client, err := joinTestRoom(room)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The client flooding the room before it ticks is disconnected.
+	for _, data := range [][]byte{{1}, {2}, {3}} {
+		if err := send(client.conn, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for room.clientManager.Exist(client.id) {
+		if time.Now().After(deadline) {
+			t.Fatal("client overflowing the tick queue is not disconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,56 @@
+package iguagile
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrTLSRequired is when the client connects without TLS to the server
+// requiring it.
+var ErrTLSRequired = errors.New("tls required")
+
+// NewServerTLSConfig returns a TLS config with the certificate and key files.
+// If caFile is given, clients are required to present a certificate signed by
+// the CA.
+func NewServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile == "" {
+		return config, nil
+	}
+
+	pool, err := newCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// newCertPool returns a pool of the CA certificates in the file.
+func newCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("invalid ca certificate %v", caFile)
+	}
+
+	return pool, nil
+}
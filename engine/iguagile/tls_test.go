@@ -0,0 +1,188 @@
+package iguagile
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCertificate(dir string) (certFile, keyFile string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}), 0600); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}), 0600); err != nil {
+		return "", "", err
+	}
+
+	return certFile, keyFile, nil
+}
+
+func TestNewServerTLSConfig(t *testing.T) {
+	certFile, keyFile, err := writeTestCertificate(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.NoClientCert {
+		t.Errorf("client certificate required without ca %v", config.ClientAuth)
+	}
+
+	config, err = NewServerTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ClientAuth != tls.RequireAndVerifyClientCert || config.ClientCAs == nil {
+		t.Errorf("client certificate not required with ca %v", config.ClientAuth)
+	}
+
+	if _, err := NewServerTLSConfig(certFile, keyFile, keyFile); err == nil {
+		t.Error("invalid ca accepted")
+	}
+}
+
+func TestRoomServerMutualTLS(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile, err := writeTestCertificate(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The certificate is self-signed, so the server and the clients trust it
+	// as the CA.
+	config, err := NewServerTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener = tls.NewListener(listener, config)
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				if err := room.server.Serve(conn); err != nil {
+					_ = conn.Close()
+				}
+			}()
+		}
+	}()
+
+	pool, err := newCertPool(certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(conn, testData); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], testData) {
+		t.Errorf("invalid data %v, %v", buf[:n], testData)
+	}
+
+	otherCertFile, otherKeyFile, err := writeTestCertificate(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherCert, err := tls.LoadX509KeyPair(otherCertFile, otherKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, certificates := range map[string][]tls.Certificate{
+		"missing": nil,
+		"wrong":   {otherCert},
+	} {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+			Certificates: certificates,
+			RootCAs:      pool,
+		})
+		if err != nil {
+			// The server may reject the certificate before the dial returns.
+			continue
+		}
+
+		if err := verify(conn); err == nil {
+			t.Errorf("client with %v certificate accepted", name)
+		}
+		_ = conn.Close()
+	}
+}
@@ -0,0 +1,53 @@
+package iguagile
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/websocket"
+)
+
+// webSocketConn is a WebSocket connection notifies when it is closed.
+type webSocketConn struct {
+	*websocket.Conn
+	closed chan struct{}
+	once   *sync.Once
+}
+
+func newWebSocketConn(ws *websocket.Conn) *webSocketConn {
+	ws.PayloadType = websocket.BinaryFrame
+	return &webSocketConn{
+		Conn:   ws,
+		closed: make(chan struct{}),
+		once:   &sync.Once{},
+	}
+}
+
+// Close closes the connection.
+func (c *webSocketConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
+
+// WebSocketHandler returns a handler accepts clients over WebSocket.
+// Clients send the same messages as the stream connection in binary frames.
+func (s *RoomServer) WebSocketHandler() http.Handler {
+	return websocket.Handler(func(ws *websocket.Conn) {
+		conn := newWebSocketConn(ws)
+		if err := s.Serve(conn); err != nil {
+			s.logger.Println(err)
+			return
+		}
+
+		// The connection is closed when the handler returns.
+		<-conn.closed
+	})
+}
+
+// ServeWebSocket accepts clients over WebSocket on the listener.
+func (s *RoomServer) ServeWebSocket(listener net.Listener) error {
+	return http.Serve(listener, s.WebSocketHandler())
+}
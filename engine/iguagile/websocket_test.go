@@ -0,0 +1,53 @@
+package iguagile
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestWebSocket(t *testing.T) {
+	room, err := newTestRoom(RelayServiceFactory{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = listener.Close() }()
+
+	go func() {
+		_ = room.server.ServeWebSocket(listener)
+	}()
+
+	url := fmt.Sprintf("ws://%v/", listener.Addr())
+	conn, err := websocket.Dial(url, "", "http://localhost/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = conn.Close() }()
+	conn.PayloadType = websocket.BinaryFrame
+
+	if err := verify(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := send(conn, testData); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := receive(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf[:n], testData) {
+		t.Errorf("invalid data %v, %v", buf[:n], testData)
+	}
+}